@@ -0,0 +1,47 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Checkpoint records how far a grabber run has got through a posts endpoint,
+// so an interrupted full backfill can pick up where it stopped.
+type Checkpoint struct {
+	Endpoint  string    `bson:"_id"`
+	RunId     string    `bson:"run_id"`
+	Page      int       `bson:"page"`
+	PageCount int       `bson:"page_count"`
+	Started   time.Time `bson:"started"`
+	Updated   time.Time `bson:"updated"`
+}
+
+// LoadCheckpoint returns the checkpoint for endpoint, or nil if there is none.
+func (db *DB) LoadCheckpoint(ctx context.Context, endpoint string) (*Checkpoint, error) {
+	var cp Checkpoint
+	err := db.GrabberCheckpoints.FindOne(ctx, bson.M{"_id": endpoint}).Decode(&cp)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+func (db *DB) SaveCheckpoint(ctx context.Context, cp *Checkpoint) error {
+	cp.Updated = time.Now()
+	_, err := db.GrabberCheckpoints.ReplaceOne(ctx,
+		bson.M{"_id": cp.Endpoint}, cp, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (db *DB) ClearCheckpoint(ctx context.Context, endpoint string) error {
+	_, err := db.GrabberCheckpoints.DeleteOne(ctx, bson.M{"_id": endpoint})
+	return err
+}
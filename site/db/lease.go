@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Lease is a lock document shared by every instance pointing at the same
+// database. Whoever holds an unexpired lease owns the named resource; an
+// expired lease may be taken over by anyone.
+type Lease struct {
+	Name     string    `bson:"_id" json:"name"`
+	Owner    string    `bson:"owner" json:"owner"`
+	Acquired time.Time `bson:"acquired" json:"acquired"`
+	Renewed  time.Time `bson:"renewed" json:"renewed"`
+	Expires  time.Time `bson:"expires" json:"expires"`
+}
+
+// AcquireLease takes the lease called name for owner if it is free, expired or
+// already held by owner. It reports false when another owner holds it.
+func (db *DB) AcquireLease(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	filter := bson.M{
+		"_id": name,
+		"$or": bson.A{
+			bson.M{"expires": bson.M{"$lt": now}},
+			bson.M{"owner": owner},
+		},
+	}
+	update := bson.M{"$set": bson.M{
+		"owner":    owner,
+		"acquired": now,
+		"renewed":  now,
+		"expires":  now.Add(ttl),
+	}}
+
+	_, err := db.GrabberLocks.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// The lease exists and is held by someone else, so the filter did
+		// not match and the upsert collided with their document.
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RenewLease extends a lease held by owner. It reports false if the lease has
+// been taken over in the meantime.
+func (db *DB) RenewLease(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	res, err := db.GrabberLocks.UpdateOne(ctx,
+		bson.M{"_id": name, "owner": owner},
+		bson.M{"$set": bson.M{"renewed": now, "expires": now.Add(ttl)}})
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount == 1, nil
+}
+
+func (db *DB) ReleaseLease(ctx context.Context, name, owner string) error {
+	_, err := db.GrabberLocks.DeleteOne(ctx, bson.M{"_id": name, "owner": owner})
+	return err
+}
+
+// LoadLease returns the lease called name, or nil if nobody has taken it.
+func (db *DB) LoadLease(ctx context.Context, name string) (*Lease, error) {
+	var l Lease
+	err := db.GrabberLocks.FindOne(ctx, bson.M{"_id": name}).Decode(&l)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/findthisplace.eu/dirty"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PostRevision records the fields of a post that changed between two grabs.
+type PostRevision struct {
+	Id       primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	PostId   int                `bson:"post_id" json:"post_id"`
+	Recorded time.Time          `bson:"recorded" json:"recorded"`
+	Changed  time.Time          `bson:"changed" json:"changed"`
+	Changes  []FieldChange      `bson:"changes" json:"changes"`
+}
+
+// FieldChange is a single changed field with its value before and after.
+type FieldChange struct {
+	Field string      `bson:"field" json:"field"`
+	Old   interface{} `bson:"old" json:"old"`
+	New   interface{} `bson:"new" json:"new"`
+}
+
+// revisionFields is the part of a stored post that is tracked for changes.
+type revisionFields struct {
+	Id       int      `bson:"_id"`
+	Title    string   `bson:"title"`
+	Text     string   `bson:"text"`
+	Tags     []string `bson:"tags"`
+	Rating   int      `bson:"rating"`
+	IsGolden bool     `bson:"golden"`
+}
+
+// recordRevisions compares posts about to be saved with their stored copies
+// and writes a revision for each one that changed. Posts seen for the first
+// time have nothing to compare against and are skipped.
+func (db *DB) recordRevisions(ctx context.Context, posts []dirty.DirtyPost) error {
+	if len(posts) == 0 {
+		return nil
+	}
+
+	ids := make([]int, len(posts))
+	for i, p := range posts {
+		ids[i] = p.Id
+	}
+
+	cur, err := db.DirtyPosts.Find(ctx, bson.M{"_id": bson.M{"$in": ids}},
+		options.Find().SetProjection(bson.M{"title": 1, "text": 1, "tags": 1, "rating": 1, "golden": 1}))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	stored := make(map[int]revisionFields, len(posts))
+	for cur.Next(ctx) {
+		var row revisionFields
+		if err := cur.Decode(&row); err != nil {
+			return err
+		}
+		stored[row.Id] = row
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var revisions []interface{}
+	for _, p := range posts {
+		prev, ok := stored[p.Id]
+		if !ok {
+			continue
+		}
+		changes := diffPost(prev, p)
+		if len(changes) == 0 {
+			continue
+		}
+		revisions = append(revisions, PostRevision{
+			PostId:   p.Id,
+			Recorded: now,
+			Changed:  p.ChangedDate.Time,
+			Changes:  changes,
+		})
+	}
+	if len(revisions) == 0 {
+		return nil
+	}
+
+	_, err = db.PostRevisions.InsertMany(ctx, revisions)
+	return err
+}
+
+func diffPost(prev revisionFields, p dirty.DirtyPost) []FieldChange {
+	var changes []FieldChange
+	if prev.Title != p.Title {
+		changes = append(changes, FieldChange{Field: "title", Old: prev.Title, New: p.Title})
+	}
+	if prev.Text != p.Text {
+		changes = append(changes, FieldChange{Field: "text", Old: prev.Text, New: p.Text})
+	}
+	if !slices.Equal(prev.Tags, p.Tags) {
+		changes = append(changes, FieldChange{Field: "tags", Old: prev.Tags, New: p.Tags})
+	}
+	if prev.Rating != p.Rating {
+		changes = append(changes, FieldChange{Field: "rating", Old: prev.Rating, New: p.Rating})
+	}
+	if prev.IsGolden != p.IsGolden {
+		changes = append(changes, FieldChange{Field: "golden", Old: prev.IsGolden, New: p.IsGolden})
+	}
+	return changes
+}
+
+// ListPostRevisions returns the revisions of a post, oldest first.
+func (db *DB) ListPostRevisions(ctx context.Context, postId int) ([]PostRevision, error) {
+	cur, err := db.PostRevisions.Find(ctx, bson.M{"post_id": postId},
+		options.Find().SetSort(bson.D{{Key: "recorded", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	revisions := make([]PostRevision, 0)
+	if err := cur.All(ctx, &revisions); err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
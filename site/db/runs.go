@@ -0,0 +1,101 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	RunModeFull        = "full"
+	RunModeIncremental = "incremental"
+	RunModePosts       = "posts"
+	// RunModeReprocess fetches nothing and re-runs ftp processing over the
+	// stored archive.
+	RunModeReprocess = "reprocess"
+
+	RunStatusRunning = "running"
+	RunStatusSuccess = "success"
+	RunStatusFail    = "fail"
+)
+
+// GrabberRun is the history record of a single grabber run.
+type GrabberRun struct {
+	Id               string     `bson:"_id" json:"id"`
+	Mode             string     `bson:"mode" json:"mode"`
+	Status           string     `bson:"status" json:"status"`
+	Started          time.Time  `bson:"started" json:"started"`
+	Finished         *time.Time `bson:"finished,omitempty" json:"finished,omitempty"`
+	Resumed          bool       `bson:"resumed" json:"resumed"`
+	PostIds          []int      `bson:"post_ids,omitempty" json:"post_ids,omitempty"`
+	Pages            int        `bson:"pages" json:"pages"`
+	PageFailures     int        `bson:"page_failures" json:"page_failures"`
+	Posts            int        `bson:"posts" json:"posts"`
+	Comments         int        `bson:"comments" json:"comments"`
+	Users            int        `bson:"users" json:"users"`
+	CommentFailures  int        `bson:"comment_failures" json:"comment_failures"`
+	PostsFound       int        `bson:"posts_found" json:"posts_found"`
+	PostsVanished    int        `bson:"posts_vanished" json:"posts_vanished"`
+	CommentsVanished int        `bson:"comments_vanished" json:"comments_vanished"`
+	// What ftp processing changed, compared with the stored posts.
+	PostsUnfound      int    `bson:"posts_unfound" json:"posts_unfound"`
+	PostsMoved        int    `bson:"posts_moved" json:"posts_moved"`
+	FindersChanged    int    `bson:"finders_changed" json:"finders_changed"`
+	PostsManual       int    `bson:"posts_manual" json:"posts_manual"`
+	CommentsExtracted int    `bson:"comments_extracted" json:"comments_extracted"`
+	CommentsMoved     int    `bson:"comments_moved" json:"comments_moved"`
+	Error             string `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+func NewGrabberRun(mode string) *GrabberRun {
+	return &GrabberRun{
+		Id:      primitive.NewObjectID().Hex(),
+		Mode:    mode,
+		Status:  RunStatusRunning,
+		Started: time.Now(),
+	}
+}
+
+func (db *DB) SaveGrabberRun(ctx context.Context, run *GrabberRun) error {
+	_, err := db.GrabberRuns.ReplaceOne(ctx,
+		bson.M{"_id": run.Id}, run, options.Replace().SetUpsert(true))
+	return err
+}
+
+// LoadGrabberRun returns the run with the given id, or nil if there is none.
+func (db *DB) LoadGrabberRun(ctx context.Context, id string) (*GrabberRun, error) {
+	var run GrabberRun
+	err := db.GrabberRuns.FindOne(ctx, bson.M{"_id": id}).Decode(&run)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// ListGrabberRuns returns the most recent runs first.
+func (db *DB) ListGrabberRuns(ctx context.Context, limit int) ([]GrabberRun, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "started", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+
+	cur, err := db.GrabberRuns.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	runs := make([]GrabberRun, 0)
+	if err := cur.All(ctx, &runs); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
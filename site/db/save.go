@@ -3,6 +3,7 @@ package db
 import (
 	"context"
 	"fmt"
+	"iter"
 	"log"
 
 	"github.com/findthisplace.eu/dirty"
@@ -17,6 +18,9 @@ func (db *DB) Save(ctx context.Context, posts []dirty.DirtyPost, comments []dirt
 	if err := db.upsertUsers(ctx, users); err != nil {
 		return err
 	}
+	if err := db.recordRevisions(ctx, posts); err != nil {
+		return err
+	}
 	if err := db.upsertMany(ctx, db.DirtyPosts, anySlice(posts)); err != nil {
 		return err
 	}
@@ -48,6 +52,10 @@ func (db *DB) upsertUsers(ctx context.Context, users map[int]*dirty.DirtyUser) e
 }
 
 func (db *DB) upsertMany(ctx context.Context, coll *mongo.Collection, docs []interface{}) error {
+	if len(docs) == 0 {
+		return nil
+	}
+
 	models := make([]mongo.WriteModel, len(docs))
 
 	for i, v := range docs {
@@ -71,6 +79,46 @@ func (db *DB) upsertMany(ctx context.Context, coll *mongo.Collection, docs []int
 	return err
 }
 
+// DirtyPostIDs iterates over the ids of every stored post that has not
+// vanished. The ids, a few thousand ints, are read before the first is
+// yielded: the caller processes them in slow chunks, and a cursor left open
+// that long would be killed by the server's idle timeout. A failure is
+// yielded as the only element.
+func (db *DB) DirtyPostIDs(ctx context.Context) iter.Seq2[int, error] {
+	return func(yield func(int, error) bool) {
+		cur, err := db.DirtyPosts.Find(ctx, bson.M{"vanished": NotVanished},
+			options.Find().SetProjection(bson.M{"_id": 1}))
+		if err != nil {
+			yield(0, err)
+			return
+		}
+		var rows []struct {
+			Id int `bson:"_id"`
+		}
+		if err := cur.All(ctx, &rows); err != nil {
+			yield(0, err)
+			return
+		}
+
+		for _, row := range rows {
+			if !yield(row.Id, nil) {
+				return
+			}
+		}
+	}
+}
+
+// IDs adapts a plain slice of ids to the iterator form used by DirtyPostIDs.
+func IDs(ids []int) iter.Seq2[int, error] {
+	return func(yield func(int, error) bool) {
+		for _, id := range ids {
+			if !yield(id, nil) {
+				return
+			}
+		}
+	}
+}
+
 func anySlice[T any](in []T) []interface{} {
 	out := make([]interface{}, len(in))
 	for i, v := range in {
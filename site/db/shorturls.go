@@ -0,0 +1,55 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// ShortURLResolved means the redirects led to a URL with coordinates.
+	ShortURLResolved = "resolved"
+	// ShortURLNoCoords means the redirects were followed to the end without
+	// finding coordinates.
+	ShortURLNoCoords = "no_coords"
+	// ShortURLFailed means the redirects could not be followed, usually a
+	// network error or the provider refusing us.
+	ShortURLFailed = "failed"
+)
+
+// ShortURL is the cached outcome of resolving a short map link.
+type ShortURL struct {
+	URL      string    `bson:"_id" json:"url"`
+	Status   string    `bson:"status" json:"status"`
+	FinalURL string    `bson:"final_url,omitempty" json:"final_url,omitempty"`
+	Lat      float64   `bson:"lat,omitempty" json:"lat,omitempty"`
+	Lng      float64   `bson:"lng,omitempty" json:"lng,omitempty"`
+	Error    string    `bson:"error,omitempty" json:"error,omitempty"`
+	Attempts int       `bson:"attempts" json:"attempts"`
+	Checked  time.Time `bson:"checked" json:"checked"`
+	// RetryAt is when an unresolved link may be tried again.
+	RetryAt time.Time `bson:"retry_at,omitempty" json:"retry_at,omitempty"`
+}
+
+// LoadShortURL returns the cached entry for url, or nil if there is none.
+func (db *DB) LoadShortURL(ctx context.Context, url string) (*ShortURL, error) {
+	var su ShortURL
+	err := db.ShortURLs.FindOne(ctx, bson.M{"_id": url}).Decode(&su)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &su, nil
+}
+
+func (db *DB) SaveShortURL(ctx context.Context, su *ShortURL) error {
+	_, err := db.ShortURLs.ReplaceOne(ctx,
+		bson.M{"_id": su.URL}, su, options.Replace().SetUpsert(true))
+	return err
+}
@@ -20,6 +20,12 @@ type DB struct {
 	FtpComments   *mongo.Collection
 	FtpUsers      *mongo.Collection
 	FtpSettings   *mongo.Collection
+
+	GrabberCheckpoints *mongo.Collection
+	GrabberRuns        *mongo.Collection
+	GrabberLocks       *mongo.Collection
+	PostRevisions      *mongo.Collection
+	ShortURLs          *mongo.Collection
 }
 
 func Connect(ctx context.Context, dbName string) (*DB, error) {
@@ -57,5 +63,11 @@ func Connect(ctx context.Context, dbName string) (*DB, error) {
 		FtpComments:   db.Collection("ftp_comments"),
 		FtpUsers:      db.Collection("ftp_users"),
 		FtpSettings:   db.Collection("ftp_settings"),
+
+		GrabberCheckpoints: db.Collection("grabber_checkpoints"),
+		GrabberRuns:        db.Collection("grabber_runs"),
+		GrabberLocks:       db.Collection("grabber_locks"),
+		PostRevisions:      db.Collection("post_revisions"),
+		ShortURLs:          db.Collection("short_urls"),
 	}, nil
 }
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NotVanished matches documents that have not been flagged as vanished.
+// Pipelines over dirty_posts or dirty_comments use it to leave out content
+// that has been deleted on d3.ru.
+var NotVanished = bson.M{"$ne": true}
+
+// MarkPostsSeen stamps posts that were listed by d3.ru but not saved, so a
+// full run does not take them for deleted.
+func (db *DB) MarkPostsSeen(ctx context.Context, ids []int, at time.Time) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := db.DirtyPosts.UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": ids}},
+		bson.M{"$set": bson.M{"seen_at": at}, "$unset": bson.M{"vanished": "", "vanished_at": ""}})
+	return err
+}
+
+// MarkVanishedPosts flags every post not seen since the given time. It is
+// only meaningful after a full run that listed every page without failures.
+func (db *DB) MarkVanishedPosts(ctx context.Context, seenBefore time.Time) (int, error) {
+	res, err := db.DirtyPosts.UpdateMany(ctx,
+		bson.M{
+			"seen_at":  bson.M{"$not": bson.M{"$gte": seenBefore}},
+			"vanished": NotVanished,
+		},
+		bson.M{"$set": bson.M{"vanished": true, "vanished_at": time.Now()}})
+	if err != nil {
+		return 0, err
+	}
+	return int(res.ModifiedCount), nil
+}
+
+// MarkVanishedComments flags, for each post in kept, the stored comments that
+// are missing from its latest list of comment ids.
+func (db *DB) MarkVanishedComments(ctx context.Context, kept map[int][]int) (int, error) {
+	if len(kept) == 0 {
+		return 0, nil
+	}
+
+	now := time.Now()
+	models := make([]mongo.WriteModel, 0, len(kept))
+	for postId, ids := range kept {
+		if ids == nil {
+			ids = []int{}
+		}
+		models = append(models, mongo.NewUpdateManyModel().
+			SetFilter(bson.M{
+				"post_id":  postId,
+				"_id":      bson.M{"$nin": ids},
+				"vanished": NotVanished,
+			}).
+			SetUpdate(bson.M{"$set": bson.M{"vanished": true, "vanished_at": now}}))
+	}
+
+	res, err := db.DirtyComments.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return 0, err
+	}
+	return int(res.ModifiedCount), nil
+}
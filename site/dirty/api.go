@@ -3,11 +3,15 @@ package dirty
 import (
 	"net/http"
 	"time"
+
+	"github.com/findthisplace.eu/ratelimit"
 )
 
 type DirtyApiClient struct {
-	HTTP *http.Client
-	Base string
+	HTTP    *http.Client
+	Base    string
+	Retry   RetryPolicy
+	Limiter *ratelimit.Limiter
 }
 
 func New(endpoint string) *DirtyApiClient {
@@ -15,6 +19,7 @@ func New(endpoint string) *DirtyApiClient {
 		HTTP: &http.Client{
 			Timeout: 10 * time.Second,
 		},
-		Base: endpoint,
+		Base:  endpoint,
+		Retry: DefaultRetryPolicy,
 	}
 }
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
 	"net/url"
 )
 
@@ -20,23 +19,14 @@ func (c *DirtyApiClient) GetComments(ctx context.Context, post DirtyPost) (*Comm
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 
-	if err != nil {
-		return nil, err
-	}
-
-	resp, err := c.HTTP.Do(req)
+	resp, err := c.do(ctx, "GetComments", u.String())
 	if err != nil {
 		return nil, err
 	}
 
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("GetComments: unexpected status %s", resp.Status)
-	}
-
 	var pr CommentsResponse
 
 	if err := json.NewDecoder(resp.Body).Decode(&pr); err != nil {
@@ -4,5 +4,6 @@ const (
 	ApiBaseEndpoint        = "https://findthisplace.d3.ru"
 	ApiPostsFullEndpoint   = ApiBaseEndpoint + "/api/posts2"
 	ApiPostsLatestEndpoint = ApiBaseEndpoint + "/api/domains/findthisplace/feed?sorting=date_changed"
+	ApiPostEndpoint        = ApiBaseEndpoint + "/api/posts/%d"
 	ApiCommentsEndpoint    = ApiBaseEndpoint + "/api/posts/%d/comments"
 )
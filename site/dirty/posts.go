@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
 	"net/url"
 	"strconv"
 )
@@ -39,20 +38,12 @@ func (c *DirtyApiClient) GetPosts(ctx context.Context, page int, perPage int) (*
 	q.Set("domain_prefix", "findthisplace")
 	u.RawQuery = q.Encode()
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
-	if err != nil {
-		return nil, err
-	}
-	resp, err := c.HTTP.Do(req)
+	resp, err := c.do(ctx, "GetPosts", u.String())
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("GetPosts: unexpected status %s", resp.Status)
-	}
-
 	var pr PageResponse
 	if err := json.NewDecoder(resp.Body).Decode(&pr); err != nil {
 		return nil, err
@@ -61,6 +52,26 @@ func (c *DirtyApiClient) GetPosts(ctx context.Context, page int, perPage int) (*
 	return &pr, nil
 }
 
+func (c *DirtyApiClient) GetPost(ctx context.Context, id int) (*DirtyPost, error) {
+	u, err := url.Parse(fmt.Sprintf(c.Base, id))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(ctx, "GetPost", u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var p DirtyPost
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		return nil, err
+	}
+	log.Printf("Fetched post %d (%s)", p.Id, p.Title)
+	return &p, nil
+}
+
 func (p *DirtyPost) UnmarshalJSON(data []byte) error {
 
 	var tmp struct {
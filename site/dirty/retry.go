@@ -0,0 +1,159 @@
+package dirty
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how DirtyApiClient retries failed requests.
+type RetryPolicy struct {
+	MaxAttempts   int
+	BaseDelay     time.Duration
+	MaxDelay      time.Duration
+	MaxRetryAfter time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:   5,
+	BaseDelay:     time.Second,
+	MaxDelay:      30 * time.Second,
+	MaxRetryAfter: 2 * time.Minute,
+}
+
+// StatusError is returned when d3.ru answers with a non-200 status.
+type StatusError struct {
+	Op         string
+	StatusCode int
+	Status     string
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: unexpected status %s", e.Op, e.Status)
+}
+
+// Retryable reports whether the status is worth another attempt: throttling,
+// request timeouts and server-side failures. Other 4xx answers are permanent.
+func (e *StatusError) Retryable() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusRequestTimeout:
+		return true
+	}
+	return e.StatusCode >= 500
+}
+
+// IsRetryable reports whether err, returned by a request made with ctx, is a
+// transient failure. Transport errors are retryable unless ctx is done. The
+// error itself is not checked for context errors: http.Client timeouts match
+// context.DeadlineExceeded too, and those are worth retrying.
+func IsRetryable(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Retryable()
+	}
+	return true
+}
+
+// do sends GET requests to rawURL until one returns 200, a permanent error
+// occurs or the policy runs out of attempts. The caller closes the body.
+func (c *DirtyApiClient) do(ctx context.Context, op, rawURL string) (*http.Response, error) {
+	attempts := max(c.Retry.MaxAttempts, 1)
+
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		resp, err := c.doOnce(ctx, op, rawURL)
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+
+		if !IsRetryable(ctx, err) || attempt == attempts {
+			break
+		}
+
+		delay := c.Retry.backoff(attempt)
+		var se *StatusError
+		if errors.As(err, &se) && se.RetryAfter > 0 {
+			delay = min(se.RetryAfter, c.Retry.MaxRetryAfter)
+		}
+
+		log.Printf("%s: attempt %d/%d failed (%v), retrying in %s", op, attempt, attempts, err, delay.Round(time.Millisecond))
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+	return nil, lastErr
+}
+
+func (c *DirtyApiClient) doOnce(ctx context.Context, op, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.Limiter.Wait(ctx, req.URL.Host); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &StatusError{
+			Op:         op,
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+	return resp, nil
+}
+
+// backoff returns a jittered exponential delay for the given attempt number,
+// somewhere between half and the full BaseDelay*2^(attempt-1), capped at MaxDelay.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay << (attempt - 1)
+	if d <= 0 || d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	half := d / 2
+	if half <= 0 {
+		return d
+	}
+	return half + rand.N(half)
+}
+
+// parseRetryAfter understands both forms of the header: delay in seconds and
+// an HTTP date.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -1,5 +1,7 @@
 package dirty
 
+import "time"
+
 type DirtyPost struct {
 	Id            int        `json:"id" bson:"_id"`
 	Title         string     `json:"title"`
@@ -16,6 +18,12 @@ type DirtyPost struct {
 	Link          string     `bson:"link"`
 	UrlSlug       string     `json:"url_slug" bson:"url_slug,omitempty"`
 	Tags          []string   `json:"tags" bson:"tags"`
+
+	// Set by the grabber, never by d3.ru. SeenAt is when the post was last
+	// returned by the API; Vanished marks posts a full run no longer found.
+	SeenAt     *time.Time `json:"-" bson:"seen_at,omitempty"`
+	Vanished   bool       `json:"-" bson:"vanished,omitempty"`
+	VanishedAt *time.Time `json:"-" bson:"vanished_at,omitempty"`
 }
 
 type DirtyUser struct {
@@ -42,4 +50,9 @@ type DirtyComment struct {
 	TreeLevel   int        `json:"tree_level" bson:"tree_level"`
 	DateOrder   int        `json:"date_order" bson:"date_order"`
 	RatingOrder int        `json:"rating_order" bson:"rating_order"`
+
+	// Set by the grabber when the comment is missing from a later fetch of
+	// its post's comments.
+	Vanished   bool       `json:"-" bson:"vanished,omitempty"`
+	VanishedAt *time.Time `json:"-" bson:"vanished_at,omitempty"`
 }
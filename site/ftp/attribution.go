@@ -0,0 +1,167 @@
+package ftp
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/findthisplace.eu/db"
+	"github.com/findthisplace.eu/dirty"
+	"github.com/findthisplace.eu/settings"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// defaultConfirmPhrases is used while the confirm_phrases setting is unset.
+var defaultConfirmPhrases = []string{"да!", "верно", "нашли", "правильно", "точно", "угадали"}
+
+var reTag = regexp.MustCompile(`<[^>]*>`)
+
+// threadComment is the part of a comment the attribution needs.
+type threadComment struct {
+	Id       int             `bson:"_id"`
+	ParentId int             `bson:"parent_id"`
+	UserId   int             `bson:"user_id"`
+	Created  dirty.EpochTime `bson:"created"`
+	Text     string          `bson:"body"`
+}
+
+// confirmation is a reply by the post author confirming a comment above it.
+type confirmation struct {
+	// CommentId and UserId are the confirmed comment and its author, who is
+	// credited with the find.
+	CommentId int
+	UserId    int
+	Created   dirty.EpochTime
+	// ReplyId is the author's confirming reply.
+	ReplyId int
+}
+
+// confirmMatcher matches any of the phrases as whole words, optionally
+// preceded or followed by "не", which turns a confirmation into a denial:
+// "не угадали", "точно не скажу". A following "нет" counts too, but only
+// after a phrase ending in a letter: in "да! Не ожидал" the sentence is over.
+type confirmMatcher struct {
+	re *regexp.Regexp
+}
+
+func newConfirmMatcher(phrases []string) *confirmMatcher {
+	var alts []string
+	for _, p := range phrases {
+		if p = strings.TrimSpace(strings.ToLower(p)); p != "" {
+			alts = append(alts, regexp.QuoteMeta(p))
+		}
+	}
+	if len(alts) == 0 {
+		return &confirmMatcher{}
+	}
+	// Longer phrases first, so "да!" wins over "да".
+	sort.Slice(alts, func(i, j int) bool { return len(alts[i]) > len(alts[j]) })
+	return &confirmMatcher{re: regexp.MustCompile(
+		`(?:^|[^\p{L}])(не\s+)?(` + strings.Join(alts, "|") + `)(?:(\s+нет?)(?:$|[^\p{L}])|$|[^\p{L}])`)}
+}
+
+// Match reports whether text confirms, i.e. has a phrase that is not negated.
+func (m *confirmMatcher) Match(text string) bool {
+	if m.re == nil {
+		return false
+	}
+	text = strings.ToLower(reTag.ReplaceAllString(text, " "))
+	for _, g := range m.re.FindAllStringSubmatch(text, -1) {
+		last, _ := utf8.DecodeLastRuneInString(g[2])
+		if g[1] == "" && (g[3] == "" || !unicode.IsLetter(last)) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadConfirmMatcher builds the matcher from settings, falling back to
+// defaultConfirmPhrases while the setting is unset or empty.
+func loadConfirmMatcher(ctx context.Context, sm *settings.Manager) *confirmMatcher {
+	phrases := defaultConfirmPhrases
+	if sm != nil {
+		if p, err := sm.GetConfirmPhrases(ctx); err == nil && len(p) > 0 {
+			phrases = p
+		}
+	}
+	return newConfirmMatcher(phrases)
+}
+
+func loadThreads(ctx context.Context, store *db.DB, postIDs []int) (map[int][]threadComment, error) {
+	cur, err := store.DirtyComments.Find(ctx,
+		bson.M{"post_id": bson.M{"$in": postIDs}, "vanished": db.NotVanished},
+		options.Find().
+			SetProjection(bson.M{"post_id": 1, "parent_id": 1, "user_id": 1, "created": 1, "body": 1}).
+			SetSort(bson.D{{Key: "created", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	result := make(map[int][]threadComment)
+	for cur.Next(ctx) {
+		var row struct {
+			threadComment `bson:",inline"`
+			PostId        int `bson:"post_id"`
+		}
+		if err := cur.Decode(&row); err != nil {
+			return nil, err
+		}
+		result[row.PostId] = append(result[row.PostId], row.threadComment)
+	}
+	return result, cur.Err()
+}
+
+// attribute walks the thread, oldest first, for the author's first reply
+// that confirms a comment, and returns the comment it confirms: the nearest
+// ancestor of the reply not written by the author, since authors often
+// answer in several steps ("хм..." then "да!"). It returns false if the
+// author never confirmed anyone.
+func attribute(authorId int, thread []threadComment, m *confirmMatcher) (confirmation, bool) {
+	byId := make(map[int]threadComment, len(thread))
+	for _, c := range thread {
+		byId[c.Id] = c
+	}
+
+	for _, c := range thread {
+		if c.UserId != authorId || c.ParentId == 0 || !m.Match(c.Text) {
+			continue
+		}
+		parent, ok := byId[c.ParentId]
+		// The step limit guards against a parent_id cycle in bad data.
+		for steps := 0; ok && parent.UserId == authorId && steps < len(thread); steps++ {
+			parent, ok = byId[parent.ParentId]
+		}
+		if !ok || parent.UserId == authorId || parent.UserId == 0 {
+			continue
+		}
+		return confirmation{
+			CommentId: parent.Id,
+			UserId:    parent.UserId,
+			Created:   parent.Created,
+			ReplyId:   c.Id,
+		}, true
+	}
+	return confirmation{}, false
+}
+
+// preferComment moves the candidates from commentId to the front, keeping
+// the order otherwise, so the confirmed comment's coordinates are used.
+func preferComment(cands []PostCandidate, commentId int) []PostCandidate {
+	out := make([]PostCandidate, 0, len(cands))
+	for _, c := range cands {
+		if c.CommentId == commentId {
+			out = append(out, c)
+		}
+	}
+	for _, c := range cands {
+		if c.CommentId != commentId {
+			out = append(out, c)
+		}
+	}
+	return out
+}
@@ -0,0 +1,101 @@
+package ftp
+
+import "math"
+
+// Reason codes explaining a post's confidence score.
+const (
+	ReasonManualOverride  = "manual_override"
+	ReasonAuthorConfirmed = "author_confirmed"
+	ReasonLocatedComment  = "located_comment"
+	ReasonTopComment      = "fallback_top_comment"
+	ReasonNoFinder        = "no_finder"
+	ReasonPrecise         = "precise_coordinates"
+	ReasonImprecise       = "imprecise_coordinates"
+	ReasonCorroborated    = "corroborated"
+	ReasonConflicting     = "conflicting_candidates"
+	ReasonMarkerDate      = "marker_date"
+)
+
+// agreeKm is how close candidates from different comments must be to count
+// as pointing at the same place.
+const agreeKm = 1.0
+
+// score sets the confidence and reasons of a found post from how its location
+// and finder were arrived at. Confidence runs from 0 (a guess) to 1 (set by
+// an admin), starting from the kind of evidence used and nudged by how
+// precise the coordinates are and whether other comments agree.
+func score(fp *FtpPost) {
+	fp.Confidence = 0
+	fp.Reasons = nil
+	if !fp.IsFound {
+		return
+	}
+
+	located := fp.Latitude != 0 || fp.Longitude != 0
+	var conf float64
+	switch {
+	case fp.ConfirmationId != 0 && located:
+		conf = 0.8
+		fp.Reasons = append(fp.Reasons, ReasonAuthorConfirmed)
+	case fp.ConfirmationId != 0:
+		conf = 0.5
+		fp.Reasons = append(fp.Reasons, ReasonAuthorConfirmed)
+	case located:
+		conf = 0.6
+		fp.Reasons = append(fp.Reasons, ReasonLocatedComment)
+	case fp.FoundById != 0:
+		conf = 0.3
+		fp.Reasons = append(fp.Reasons, ReasonTopComment)
+	default:
+		fp.Reasons = append(fp.Reasons, ReasonNoFinder)
+	}
+
+	if len(fp.Candidates) > 0 {
+		top := fp.Candidates[0]
+		switch {
+		case top.Precision >= 4:
+			conf += 0.1
+			fp.Reasons = append(fp.Reasons, ReasonPrecise)
+		case top.Precision < 3:
+			conf -= 0.2
+			fp.Reasons = append(fp.Reasons, ReasonImprecise)
+		}
+
+		others, agree := 0, false
+		for _, c := range fp.Candidates[1:] {
+			if c.CommentId == top.CommentId {
+				continue
+			}
+			others++
+			if distanceKm(top.Lat, top.Lng, c.Lat, c.Lng) <= agreeKm {
+				agree = true
+			}
+		}
+		switch {
+		case agree:
+			conf += 0.2
+			fp.Reasons = append(fp.Reasons, ReasonCorroborated)
+		case others > 0:
+			conf -= 0.2
+			fp.Reasons = append(fp.Reasons, ReasonConflicting)
+		}
+	}
+
+	if fp.FoundDateSource == FoundDateMarker {
+		conf += 0.1
+		fp.Reasons = append(fp.Reasons, ReasonMarkerDate)
+	}
+
+	fp.Confidence = math.Round(min(max(conf, 0), 1)*100) / 100
+}
+
+// distanceKm is the great-circle distance between two points.
+func distanceKm(lat1, lng1, lat2, lng2 float64) float64 {
+	const earthRadiusKm = 6371.0
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLng := (lng2 - lng1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
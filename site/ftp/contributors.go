@@ -0,0 +1,60 @@
+package ftp
+
+// Contributor roles.
+const (
+	// ContributorFinder is the user credited with the find, always first.
+	ContributorFinder = "finder"
+	// ContributorHint is a user whose comment led up to the find.
+	ContributorHint = "hint"
+)
+
+// Contributor is one user's share in a find. The weights of a post's
+// contributors add up to 1.
+type Contributor struct {
+	UserId int     `bson:"user_id" json:"user_id"`
+	Role   string  `bson:"role" json:"role"`
+	Weight float64 `bson:"weight" json:"weight"`
+}
+
+// hintShare is the part of the credit split between hint givers, and
+// maxHints how many of them are credited at most.
+const (
+	hintShare = 0.4
+	maxHints  = 3
+)
+
+// contributors credits the finder and the users who wrote the comments the
+// finding comment replies to, nearest first: places are often found by one
+// person building on a thread of guesses. The post author, who answers
+// questions in the thread, is never credited.
+func contributors(finderId, commentId, authorId int, thread []threadComment) []Contributor {
+	if finderId == 0 {
+		return nil
+	}
+
+	byId := make(map[int]threadComment, len(thread))
+	for _, c := range thread {
+		byId[c.Id] = c
+	}
+
+	var hints []int
+	seen := map[int]bool{finderId: true, authorId: true, 0: true}
+	c, ok := byId[commentId]
+	for steps := 0; ok && len(hints) < maxHints && steps < len(thread); steps++ {
+		c, ok = byId[c.ParentId]
+		if ok && !seen[c.UserId] {
+			seen[c.UserId] = true
+			hints = append(hints, c.UserId)
+		}
+	}
+
+	if len(hints) == 0 {
+		return []Contributor{{UserId: finderId, Role: ContributorFinder, Weight: 1}}
+	}
+
+	out := []Contributor{{UserId: finderId, Role: ContributorFinder, Weight: 1 - hintShare}}
+	for _, uid := range hints {
+		out = append(out, Contributor{UserId: uid, Role: ContributorHint, Weight: hintShare / float64(len(hints))})
+	}
+	return out
+}
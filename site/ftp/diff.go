@@ -0,0 +1,214 @@
+package ftp
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/findthisplace.eu/db"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Options controls a Process call.
+type Options struct {
+	// Reextract runs comments extracted with an older ExtractorVersion
+	// through the extractors again, even if coordinates were found before.
+	// Otherwise only comments without coordinates are.
+	Reextract bool
+	// DryRun computes the new comments, posts and users and reports how they
+	// differ from the stored ones in Summary.Diff, writing nothing. Short
+	// links are only looked up in the cache, not resolved.
+	DryRun bool
+}
+
+// Diff lists what a dry run would change.
+type Diff struct {
+	Posts    []PostChange    `json:"posts"`
+	Comments []CommentChange `json:"comments"`
+	Users    []UserChange    `json:"users"`
+}
+
+// PostState is the part of a post a PostChange compares.
+type PostState struct {
+	IsFound   bool    `bson:"is_found" json:"is_found"`
+	Latitude  float64 `bson:"latitude" json:"latitude,omitempty"`
+	Longitude float64 `bson:"longitude" json:"longitude,omitempty"`
+	FoundById int     `bson:"found_by_id" json:"found_by_id,omitempty"`
+}
+
+func (s PostState) located() bool { return s.Latitude != 0 || s.Longitude != 0 }
+
+// PostChange is a post whose found state, location or finder changed.
+type PostChange struct {
+	Id         int       `json:"id"`
+	Before     PostState `json:"before"`
+	After      PostState `json:"after"`
+	NewlyFound bool      `json:"newly_found,omitempty"`
+	Unfound    bool      `json:"unfound,omitempty"`
+	Moved      bool      `json:"moved,omitempty"`
+	// MovedKm is how far the location moved, if there was one before.
+	MovedKm       float64 `json:"moved_km,omitempty"`
+	FinderChanged bool    `json:"finder_changed,omitempty"`
+}
+
+func (c PostChange) changed() bool {
+	return c.NewlyFound || c.Unfound || c.Moved || c.FinderChanged
+}
+
+// CommentChange is a comment whose first coordinates changed. Before or
+// After is nil if the comment had no coordinates.
+type CommentChange struct {
+	Id     int        `json:"id"`
+	PostId int        `json:"post_id"`
+	Before *Candidate `json:"before,omitempty"`
+	After  *Candidate `json:"after,omitempty"`
+}
+
+// UserChange is a user whose statistics changed.
+type UserChange struct {
+	Id     int     `json:"id"`
+	Before FtpUser `json:"before"`
+	After  FtpUser `json:"after"`
+}
+
+// pendingPost is a post rebuilt by a dry run, together with what the user
+// statistics need from its dirty post, since it is never written for them
+// to read back.
+type pendingPost struct {
+	post     *FtpPost
+	authorId int
+	created  time.Time
+}
+
+func pendingIDs(pending []pendingPost) bson.A {
+	ids := make(bson.A, len(pending))
+	for i, p := range pending {
+		ids[i] = p.post.Id
+	}
+	return ids
+}
+
+// diffPost compares a rebuilt post with its stored state.
+func diffPost(prev PostState, fp *FtpPost) PostChange {
+	after := PostState{
+		IsFound:   fp.IsFound,
+		Latitude:  fp.Latitude,
+		Longitude: fp.Longitude,
+		FoundById: fp.FoundById,
+	}
+	c := PostChange{Id: fp.Id, Before: prev, After: after}
+	switch {
+	case after.IsFound && !prev.IsFound:
+		c.NewlyFound = true
+	case !after.IsFound && prev.IsFound:
+		c.Unfound = true
+	case after.IsFound:
+		c.Moved = after.Latitude != prev.Latitude || after.Longitude != prev.Longitude
+		if c.Moved && prev.located() && after.located() {
+			km := distanceKm(prev.Latitude, prev.Longitude, after.Latitude, after.Longitude)
+			c.MovedKm = math.Round(km*1000) / 1000
+		}
+		c.FinderChanged = after.FoundById != prev.FoundById
+	}
+	return c
+}
+
+func (s *Summary) addPost(c PostChange) {
+	switch {
+	case c.NewlyFound:
+		s.NewlyFound++
+	case c.Unfound:
+		s.Unfound++
+	}
+	if c.Moved {
+		s.Moved++
+	}
+	if c.FinderChanged {
+		s.FinderChanged++
+	}
+	if s.Diff != nil && c.changed() {
+		s.Diff.Posts = append(s.Diff.Posts, c)
+	}
+}
+
+// addComment counts a re-extracted comment, prev being its stored state.
+func (s *Summary) addComment(postId int, prev, fc *FtpComment) {
+	s.CommentsExtracted++
+	if fc.Latitude == prev.Latitude && fc.Longitude == prev.Longitude {
+		return
+	}
+	s.CommentsMoved++
+	if s.Diff != nil {
+		s.Diff.Comments = append(s.Diff.Comments, CommentChange{
+			Id:     fc.Id,
+			PostId: postId,
+			Before: commentCandidate(prev),
+			After:  commentCandidate(fc),
+		})
+	}
+}
+
+func commentCandidate(fc *FtpComment) *Candidate {
+	if !fc.Extracted {
+		return nil
+	}
+	return &Candidate{
+		Provider:  fc.Provider,
+		URL:       fc.URL,
+		Lat:       fc.Latitude,
+		Lng:       fc.Longitude,
+		Precision: fc.Precision,
+		View:      fc.View,
+	}
+}
+
+// diffUsers compares the recomputed users with ftp_users.
+func diffUsers(ctx context.Context, store *db.DB, users map[int]*FtpUser, summary *Summary) error {
+	cur, err := store.FtpUsers.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	stored := make(map[int]FtpUser)
+	for cur.Next(ctx) {
+		var u FtpUser
+		if err := cur.Decode(&u); err != nil {
+			return err
+		}
+		stored[u.Id] = u
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+
+	for _, u := range users {
+		prev := stored[u.Id]
+		if sameUser(prev, *u) {
+			continue
+		}
+		summary.UsersChanged++
+		summary.Diff.Users = append(summary.Diff.Users, UserChange{Id: u.Id, Before: prev, After: *u})
+	}
+	sort.Slice(summary.Diff.Users, func(i, j int) bool {
+		return summary.Diff.Users[i].Id < summary.Diff.Users[j].Id
+	})
+	return nil
+}
+
+// sameUser compares user statistics, allowing for rounding in the averages
+// and weights, which mongo and Go may sum in a different order.
+func sameUser(a, b FtpUser) bool {
+	near := func(x, y float64) bool {
+		return math.Abs(x-y) <= 1e-6*math.Max(1, math.Max(math.Abs(x), math.Abs(y)))
+	}
+	fa, fb := a, b
+	fa.AvgSearchTime, fb.AvgSearchTime = 0, 0
+	fa.AvgAuthorTime, fb.AvgAuthorTime = 0, 0
+	fa.FoundWeighted, fb.FoundWeighted = 0, 0
+	return fa == fb &&
+		near(a.AvgSearchTime, b.AvgSearchTime) &&
+		near(a.AvgAuthorTime, b.AvgAuthorTime) &&
+		near(a.FoundWeighted, b.FoundWeighted)
+}
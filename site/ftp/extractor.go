@@ -0,0 +1,118 @@
+package ftp
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Candidate is a pair of coordinates found in a comment.
+type Candidate struct {
+	// Provider is the name of the extractor that found the coordinates.
+	Provider string `bson:"provider" json:"provider"`
+	// URL is the link or text fragment the coordinates were read from.
+	URL string  `bson:"url" json:"url"`
+	Lat float64 `bson:"lat" json:"lat"`
+	Lng float64 `bson:"lng" json:"lng"`
+	// Precision is the number of decimal places of the less precise of the
+	// two coordinates, a rough measure of how exact the location is.
+	Precision int `bson:"precision" json:"precision"`
+	// View is set for panorama links, which also say where to look.
+	View *View `bson:"view,omitempty" json:"view,omitempty"`
+}
+
+// Extractor finds coordinates in comment text. Implementations are
+// registered with Register and tried in registration order.
+type Extractor interface {
+	Name() string
+	Extract(text string) []Candidate
+}
+
+var extractors []Extractor
+
+// Register adds an extractor to the registry. It panics if an extractor with
+// the same name is already registered, so it is meant to be called from init.
+func Register(e Extractor) {
+	for _, x := range extractors {
+		if x.Name() == e.Name() {
+			panic(fmt.Sprintf("ftp: extractor %q registered twice", e.Name()))
+		}
+	}
+	extractors = append(extractors, e)
+}
+
+// Extractors returns the registered extractors in the order they are tried.
+func Extractors() []Extractor {
+	return append([]Extractor(nil), extractors...)
+}
+
+// ExtractorFunc adapts a plain function to the Extractor interface.
+type ExtractorFunc struct {
+	Provider string
+	Fn       func(text string) []Candidate
+}
+
+func (f ExtractorFunc) Name() string                    { return f.Provider }
+func (f ExtractorFunc) Extract(text string) []Candidate { return f.Fn(text) }
+
+// pattern is a regexp whose submatches hold a latitude and a longitude.
+type pattern struct {
+	re       *regexp.Regexp
+	lat, lng int
+}
+
+func latLng(re *regexp.Regexp) pattern { return pattern{re: re, lat: 1, lng: 2} }
+func lngLat(re *regexp.Regexp) pattern { return pattern{re: re, lat: 2, lng: 1} }
+
+// regexpExtractor is an Extractor built from a list of patterns, which covers
+// most map providers: their links carry the coordinates in a fixed spot.
+type regexpExtractor struct {
+	name     string
+	patterns []pattern
+}
+
+func (e *regexpExtractor) Name() string { return e.name }
+
+func (e *regexpExtractor) Extract(text string) []Candidate {
+	var out []Candidate
+	for _, p := range e.patterns {
+		for _, m := range p.re.FindAllStringSubmatch(text, -1) {
+			if c := newCandidate(e.name, m[0], m[p.lat], m[p.lng]); c != nil {
+				out = appendCandidate(out, *c)
+			}
+		}
+	}
+	return out
+}
+
+func newCandidate(provider, matched, latStr, lngStr string) *Candidate {
+	c := parseLatLng(latStr, lngStr)
+	if c == nil {
+		return nil
+	}
+	return &Candidate{
+		Provider:  provider,
+		URL:       matched,
+		Lat:       c.Lat,
+		Lng:       c.Lng,
+		Precision: min(decimals(latStr), decimals(lngStr)),
+	}
+}
+
+// appendCandidate appends c unless out already holds the same coordinates;
+// one link often matches several patterns.
+func appendCandidate(out []Candidate, c Candidate) []Candidate {
+	for _, x := range out {
+		if x.Lat == c.Lat && x.Lng == c.Lng {
+			return out
+		}
+	}
+	return append(out, c)
+}
+
+func decimals(s string) int {
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		return len(s) - i - 1
+	}
+	return 0
+}
@@ -0,0 +1,54 @@
+package ftp
+
+import (
+	"context"
+	"time"
+
+	"github.com/findthisplace.eu/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// loadMarkerDates returns, per post, when its text first gained a found
+// marker (see foundRules.Marked) according to the revision history. The date is the post's
+// changed date as reported by d3.ru at that grab, which is when the author
+// made the edit; the grab time is used if d3.ru did not report one.
+//
+// Posts that were already marked when first grabbed have no such revision
+// and are left out.
+func loadMarkerDates(ctx context.Context, store *db.DB, postIDs []int, rules *foundRules) (map[int]time.Time, error) {
+	cur, err := store.PostRevisions.Find(ctx,
+		bson.M{"post_id": bson.M{"$in": postIDs}, "changes.field": "text"},
+		options.Find().SetSort(bson.D{{Key: "recorded", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	result := make(map[int]time.Time)
+	for cur.Next(ctx) {
+		var rev db.PostRevision
+		if err := cur.Decode(&rev); err != nil {
+			return nil, err
+		}
+		if _, ok := result[rev.PostId]; ok {
+			continue
+		}
+		for _, c := range rev.Changes {
+			if c.Field != "text" {
+				continue
+			}
+			oldText, _ := c.Old.(string)
+			newText, _ := c.New.(string)
+			if rules.Marked(oldText) || !rules.Marked(newText) {
+				continue
+			}
+			if rev.Changed.IsZero() {
+				result[rev.PostId] = rev.Recorded
+			} else {
+				result[rev.PostId] = rev.Changed
+			}
+		}
+	}
+	return result, cur.Err()
+}
@@ -1,6 +1,9 @@
 package ftp
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"net/http/cookiejar"
@@ -9,21 +12,74 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/findthisplace.eu/db"
+	"github.com/findthisplace.eu/ratelimit"
 )
 
 type coords struct {
 	Lat, Lng float64
 }
 
-var extractors = []func(string) *coords{
-	extractGoogle,
-	extractYandex,
-	extractBing,
-	extractOSM,
+func init() {
+	// Panoramas come before the plain map extractors, which would match the
+	// same links but lose the view direction.
+	Register(ExtractorFunc{Provider: "streetview", Fn: extractStreetView})
+	Register(ExtractorFunc{Provider: "yandex_panorama", Fn: extractYandexPanorama})
+	Register(&regexpExtractor{name: "google", patterns: []pattern{
+		latLng(reGoogleAt), latLng(reGoogleLL), latLng(reGoogleQ),
+		latLng(reGoogleQuery), latLng(reGoogleSearch), latLng(reGoogleData),
+	}})
+	Register(&regexpExtractor{name: "yandex", patterns: []pattern{
+		lngLat(reYandexLL), lngLat(reYandexPT),
+	}})
+	Register(&regexpExtractor{name: "bing", patterns: []pattern{
+		latLng(reBingCP), latLng(reBingSP),
+	}})
+	Register(ExtractorFunc{Provider: "osm", Fn: extractOSM})
+	Register(&regexpExtractor{name: "2gis", patterns: []pattern{
+		lngLat(re2GISM), lngLat(re2GISGeo),
+	}})
+	Register(&regexpExtractor{name: "apple", patterns: []pattern{
+		latLng(reAppleLL), latLng(reAppleQ), latLng(reAppleCoordinate),
+	}})
+	Register(&regexpExtractor{name: "mapy", patterns: []pattern{
+		// The marker id is where the user clicked; x/y is only the map centre.
+		lngLat(reMapyCoor), lngLat(reMapyXY), latLng(reMapyYX),
+	}})
+	Register(&regexpExtractor{name: "here", patterns: []pattern{
+		latLng(reHereMap), latLng(reHereShare),
+	}})
+	Register(&regexpExtractor{name: "wikimapia", patterns: []pattern{
+		latLng(reWikimapia),
+	}})
+
+	// Plain text goes last so that a link, which is unambiguous, wins over
+	// numbers typed next to it.
+	Register(textExtractor{})
 }
 
 var reShortGoogleURL = regexp.MustCompile(`https?://(?:maps\.app\.goo\.gl|goo\.gl/maps)/[^\s"'<>]+`)
 var reShortYandexURL = regexp.MustCompile(`https?://yandex\.[a-z.]+/maps/\p{Pd}/[^\s"'<>]+`)
+var reShort2GISURL = regexp.MustCompile(`https?://go\.2gis\.com/[^\s"'<>]+`)
+var reShortAppleURL = regexp.MustCompile(`https?://maps\.apple/p/[^\s"'<>]+`)
+var reShortMapyURL = regexp.MustCompile(`https?://(?:www\.)?mapy\.(?:cz|com)/s/[^\s"'<>]+`)
+var reShortHereURL = regexp.MustCompile(`https?://her\.is/[^\s"'<>]+`)
+
+// shortURLs lists the short link forms resolveShortURL follows, in the order
+// they are looked for, with the provider credited when the final URL only
+// yields coordinates through its query parameters.
+var shortURLs = []struct {
+	provider string
+	re       *regexp.Regexp
+}{
+	{"google", reShortGoogleURL},
+	{"yandex", reShortYandexURL},
+	{"2gis", reShort2GISURL},
+	{"apple", reShortAppleURL},
+	{"mapy", reShortMapyURL},
+	{"here", reShortHereURL},
+}
 
 var shortURLClient = &http.Client{
 	Timeout: 10 * time.Second,
@@ -32,18 +88,43 @@ var shortURLClient = &http.Client{
 	},
 }
 
+// shortURLLimiter throttles redirect walking per map provider host. It is nil
+// (unthrottled) until SetRateLimiter is called.
+var shortURLLimiter *ratelimit.Limiter
+
 func init() {
 	jar, _ := cookiejar.New(nil)
 	shortURLClient.Jar = jar
 }
 
-func ExtractCoords(text string) *coords {
-	for _, fn := range extractors {
-		if c := fn(text); c != nil {
-			return c
+func SetRateLimiter(l *ratelimit.Limiter) {
+	shortURLLimiter = l
+}
+
+// ExtractAll returns every set of coordinates found in text: those of each
+// registered extractor in registration order, then those behind short links.
+// With lookupOnly short links are only looked up in the cache, never walked,
+// and nothing is written.
+func ExtractAll(ctx context.Context, store *db.DB, text string, lookupOnly bool) []Candidate {
+	var out []Candidate
+	for _, e := range extractors {
+		for _, c := range e.Extract(text) {
+			out = appendCandidate(out, c)
+		}
+	}
+	for _, c := range resolveShortURLs(ctx, store, text, lookupOnly) {
+		out = appendCandidate(out, c)
+	}
+	return out
+}
+
+func firstCandidate(text string) *Candidate {
+	for _, e := range extractors {
+		if cs := e.Extract(text); len(cs) > 0 {
+			return &cs[0]
 		}
 	}
-	return resolveShortURL(text)
+	return nil
 }
 
 var reAbsoluteURL = regexp.MustCompile(`^https?://`)
@@ -57,40 +138,104 @@ const shortURLUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit
 // resolution. SOCS encodes an accepted choice and skips the wall entirely.
 const consentBypassCookie = "SOCS=CAISNQgDEitib3FfaWRlbnRpdHlmcm9udGVuZHVpc2VydmVyXzIwMjQwAEgB; CONSENT=YES+"
 
-func resolveShortURL(text string) *coords {
-	shortURL := reShortGoogleURL.FindString(text)
-	if shortURL == "" {
-		shortURL = reShortYandexURL.FindString(text)
+// resolveShortURLs resolves every short map link in text.
+func resolveShortURLs(ctx context.Context, store *db.DB, text string, lookupOnly bool) []Candidate {
+	var out []Candidate
+	for _, su := range shortURLs {
+		for _, shortURL := range su.re.FindAllString(text, -1) {
+			if c := resolveShortURL(ctx, store, su.provider, shortURL, lookupOnly); c != nil {
+				out = append(out, *c)
+			}
+		}
+	}
+	return out
+}
+
+// resolveShortURL follows the redirects of shortURL until one of them carries
+// coordinates. Outcomes are cached in short_urls when store is not nil, so
+// each link is only walked once, or again after its retry time if it could
+// not be resolved. With lookupOnly a link missing from the cache, or due for
+// a retry, is left unresolved.
+func resolveShortURL(ctx context.Context, store *db.DB, provider, shortURL string, lookupOnly bool) *Candidate {
+	cached := loadShortURL(ctx, store, shortURL)
+	if cached != nil {
+		if cached.Status == db.ShortURLResolved {
+			return candidateFromFinalURL(cached.FinalURL, shortURL, provider)
+		}
+		if time.Now().Before(cached.RetryAt) {
+			return nil
+		}
 	}
-	if shortURL == "" {
+	if lookupOnly {
 		return nil
 	}
 
 	log.Printf("geo: resolving short URL %s", shortURL)
 
+	final, status, err := walkShortURL(ctx, shortURL)
+	if ctx.Err() != nil {
+		// Interrupted rather than failed; leave the cache alone.
+		return nil
+	}
+
+	var c *Candidate
+	if status == db.ShortURLResolved {
+		if c = candidateFromFinalURL(final, shortURL, provider); c != nil {
+			log.Printf("geo: short URL %s resolved to %.6f, %.6f", shortURL, c.Lat, c.Lng)
+		}
+	}
+	saveShortURL(ctx, store, cached, shortURL, final, status, c, err)
+	return c
+}
+
+// candidateFromFinalURL reads the coordinates out of the URL a short link
+// resolved to.
+func candidateFromFinalURL(final, shortURL, provider string) *Candidate {
+	c := firstCandidate(final)
+	if c == nil {
+		if c = extractFromQuery(final); c == nil {
+			return nil
+		}
+		c.Provider = provider
+	}
+	c.URL = shortURL
+	return c
+}
+
+// walkShortURL follows the redirects of shortURL and returns the first URL
+// that yields coordinates, with the status to cache for it.
+func walkShortURL(ctx context.Context, shortURL string) (string, string, error) {
 	current := shortURL
 	for range 10 {
-		req, err := http.NewRequest("GET", current, nil)
+		req, err := http.NewRequestWithContext(ctx, "GET", current, nil)
 		if err != nil {
 			log.Printf("geo: failed to build request for %s: %v", current, err)
-			return nil
+			return current, db.ShortURLFailed, err
 		}
 		req.Header.Set("User-Agent", shortURLUserAgent)
 		req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
 		req.Header.Set("Accept-Language", "ru-RU,ru;q=0.9,en;q=0.8")
 		req.Header.Set("Cookie", consentBypassCookie)
 
+		if err := shortURLLimiter.Wait(ctx, req.URL.Host); err != nil {
+			log.Printf("geo: rate limiter aborted %s: %v", current, err)
+			return current, db.ShortURLFailed, err
+		}
+
 		resp, err := shortURLClient.Do(req)
 		if err != nil {
 			log.Printf("geo: failed to resolve short URL %s: %v", current, err)
-			return nil
+			return current, db.ShortURLFailed, err
 		}
 		resp.Body.Close()
 
 		location := resp.Header.Get("Location")
 		if location == "" {
 			log.Printf("geo: short URL %s ended at HTTP %d (no Location)", shortURL, resp.StatusCode)
-			return nil
+			if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+				return current, db.ShortURLFailed, fmt.Errorf("HTTP %d", resp.StatusCode)
+			}
+			return current, db.ShortURLNoCoords, fmt.Errorf("HTTP %d without Location", resp.StatusCode)
 		}
 
 		if !reAbsoluteURL.MatchString(location) {
@@ -111,26 +256,18 @@ func resolveShortURL(text string) *coords {
 
 		log.Printf("geo: %s -> HTTP %d -> %s", current, resp.StatusCode, location)
 
-		for _, fn := range extractors {
-			if c := fn(location); c != nil {
-				log.Printf("geo: short URL %s resolved to %.6f, %.6f", shortURL, c.Lat, c.Lng)
-				return c
-			}
-		}
-
-		if c := extractFromQuery(location); c != nil {
-			log.Printf("geo: short URL %s resolved to %.6f, %.6f (via query params)", shortURL, c.Lat, c.Lng)
-			return c
+		if firstCandidate(location) != nil || extractFromQuery(location) != nil {
+			return location, db.ShortURLResolved, nil
 		}
 
 		current = location
 	}
 
 	log.Printf("geo: short URL %s: max redirects reached, no coords found", shortURL)
-	return nil
+	return current, db.ShortURLNoCoords, errors.New("max redirects reached")
 }
 
-func extractFromQuery(rawURL string) *coords {
+func extractFromQuery(rawURL string) *Candidate {
 	parsed, err := url.Parse(rawURL)
 	if err != nil {
 		return nil
@@ -143,7 +280,7 @@ func extractFromQuery(rawURL string) *coords {
 			if val := q.Get(key); val != "" {
 				parts := strings.SplitN(val, ",", 2)
 				if len(parts) == 2 {
-					return parseLatLng(parts[1], parts[0])
+					return newCandidate("", rawURL, parts[1], parts[0])
 				}
 			}
 		}
@@ -153,7 +290,7 @@ func extractFromQuery(rawURL string) *coords {
 		if val := q.Get(key); val != "" {
 			parts := strings.SplitN(val, ",", 2)
 			if len(parts) == 2 {
-				return parseLatLng(parts[0], parts[1])
+				return newCandidate("", rawURL, parts[0], parts[1])
 			}
 		}
 	}
@@ -170,62 +307,61 @@ var (
 	reGoogleData   = regexp.MustCompile(`google\.[a-z.]+/maps[^"<>\s]*!3d(-?\d+\.?\d*)!4d(-?\d+\.?\d*)`)
 )
 
-func extractGoogle(text string) *coords {
-	for _, re := range []*regexp.Regexp{reGoogleAt, reGoogleLL, reGoogleQ, reGoogleQuery, reGoogleSearch, reGoogleData} {
-		if m := re.FindStringSubmatch(text); m != nil {
-			return parseLatLng(m[1], m[2])
-		}
-	}
-	return nil
-}
-
 var (
 	reYandexLL = regexp.MustCompile(`yandex\.[a-z.]+/maps[^"<>\s]*[?&]ll=(-?\d+\.?\d*),(-?\d+\.?\d*)`)
 	reYandexPT = regexp.MustCompile(`yandex\.[a-z.]+/maps[^"<>\s]*[?&]pt=(-?\d+\.?\d*),(-?\d+\.?\d*)`)
 )
 
-func extractYandex(text string) *coords {
-	for _, re := range []*regexp.Regexp{reYandexLL, reYandexPT} {
-		if m := re.FindStringSubmatch(text); m != nil {
-			return parseLatLng(m[2], m[1])
-		}
-	}
-	return nil
-}
-
 var (
 	reBingCP = regexp.MustCompile(`bing\.com/maps[^"<>\s]*[?&]cp=(-?\d+\.?\d*)~(-?\d+\.?\d*)`)
 	reBingSP = regexp.MustCompile(`bing\.com/maps[^"<>\s]*[?&]sp=point\.(-?\d+\.?\d*)_(-?\d+\.?\d*)`)
 )
 
-func extractBing(text string) *coords {
-	for _, re := range []*regexp.Regexp{reBingCP, reBingSP} {
-		if m := re.FindStringSubmatch(text); m != nil {
-			return parseLatLng(m[1], m[2])
-		}
-	}
-	return nil
-}
-
 var (
 	reOSMHash = regexp.MustCompile(`openstreetmap\.org[^"<>\s]*#map=\d+/(-?\d+\.?\d*)/(-?\d+\.?\d*)`)
 	reOSMMLat = regexp.MustCompile(`openstreetmap\.org[^"<>\s]*[?&]mlat=(-?\d+\.?\d*)`)
 	reOSMMLon = regexp.MustCompile(`openstreetmap\.org[^"<>\s]*[?&]mlon=(-?\d+\.?\d*)`)
 )
 
-func extractOSM(text string) *coords {
+var (
+	re2GISM   = regexp.MustCompile(`2gis\.[a-z.]+/[^"<>\s]*[?&]m=(-?\d+\.?\d*)(?:,|%2C)(-?\d+\.?\d*)`)
+	re2GISGeo = regexp.MustCompile(`2gis\.[a-z.]+/[^"<>\s]*/geo/(?:\d+/)?(-?\d+\.\d+)(?:,|%2C)(-?\d+\.\d+)`)
+)
 
+var (
+	reAppleLL         = regexp.MustCompile(`maps\.apple\.com/[^"<>\s]*[?&]s?ll=(-?\d+\.?\d*)(?:,|%2C)(-?\d+\.?\d*)`)
+	reAppleQ          = regexp.MustCompile(`maps\.apple\.com/[^"<>\s]*[?&]q=(-?\d+\.?\d*)(?:,|%2C)(-?\d+\.?\d*)`)
+	reAppleCoordinate = regexp.MustCompile(`maps\.apple\.com/[^"<>\s]*[?&]coordinate=(-?\d+\.?\d*)(?:,|%2C)(-?\d+\.?\d*)`)
+)
 
-	
-	if m := reOSMHash.FindStringSubmatch(text); m != nil {
-		return parseLatLng(m[1], m[2])
+var (
+	reMapyXY   = regexp.MustCompile(`mapy\.(?:cz|com)/[^"<>\s]*[?&]x=(-?\d+\.?\d*)&y=(-?\d+\.?\d*)`)
+	reMapyYX   = regexp.MustCompile(`mapy\.(?:cz|com)/[^"<>\s]*[?&]y=(-?\d+\.?\d*)&x=(-?\d+\.?\d*)`)
+	reMapyCoor = regexp.MustCompile(`mapy\.(?:cz|com)/[^"<>\s]*[?&]source=coor&id=(-?\d+\.?\d*)(?:,|%2C)(-?\d+\.?\d*)`)
+)
+
+var (
+	reHereMap   = regexp.MustCompile(`wego\.here\.com/[^"<>\s]*[?&]map=(-?\d+\.?\d*)(?:,|%2C)(-?\d+\.?\d*)`)
+	reHereShare = regexp.MustCompile(`share\.here\.com/l/(-?\d+\.?\d*),(-?\d+\.?\d*)`)
+)
+
+var reWikimapia = regexp.MustCompile(`wikimapia\.org[^"<>\s]*[?&#]lat=(-?\d+\.?\d*)&lon=(-?\d+\.?\d*)`)
+
+func extractOSM(text string) []Candidate {
+	var out []Candidate
+	for _, m := range reOSMHash.FindAllStringSubmatch(text, -1) {
+		if c := newCandidate("osm", m[0], m[1], m[2]); c != nil {
+			out = appendCandidate(out, *c)
+		}
 	}
 	mLat := reOSMMLat.FindStringSubmatch(text)
 	mLon := reOSMMLon.FindStringSubmatch(text)
 	if mLat != nil && mLon != nil {
-		return parseLatLng(mLat[1], mLon[1])
+		if c := newCandidate("osm", mLat[0], mLat[1], mLon[1]); c != nil {
+			out = appendCandidate(out, *c)
+		}
 	}
-	return nil
+	return out
 }
 
 func parseLatLng(latStr, lngStr string) *coords {
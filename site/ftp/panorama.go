@@ -0,0 +1,134 @@
+package ftp
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// View is the direction a panorama looks in, in degrees. Heading is clockwise
+// from north; Pitch is above (positive) or below the horizon.
+type View struct {
+	Heading float64 `bson:"heading" json:"heading"`
+	Pitch   float64 `bson:"pitch" json:"pitch"`
+}
+
+var (
+	// reStreetViewAt is the usual Street View form,
+	// google.com/maps/@lat,lng,3a,75y,90h,85t/data=!3m6!1e1...
+	// where "3a" marks a panorama, "h" is the heading and "t" the tilt.
+	reStreetViewAt = regexp.MustCompile(`google\.[a-z.]+/maps/@(-?\d+\.?\d*),(-?\d+\.?\d*),3a((?:,[\d.]+[a-z])*)`)
+	// reStreetViewAPI is the Maps URLs API form, ?map_action=pano&viewpoint=.
+	reStreetViewAPI = regexp.MustCompile(`google\.[a-z.]+/maps/@?\?[^"<>\s]*map_action=pano[^"<>\s]*`)
+	// reStreetViewCB is the legacy form, ?cbll=lat,lng&cbp=12,heading,,0,pitch.
+	reStreetViewCB = regexp.MustCompile(`google\.[a-z.]+/maps[^"<>\s]*[?&]cbll=[^"<>\s]*`)
+
+	reYandexPanorama = regexp.MustCompile(`yandex\.[a-z.]+/maps[^"<>\s]*panorama(?:\[|%5B)point(?:]|%5D)=[^"<>\s]*`)
+)
+
+func extractStreetView(text string) []Candidate {
+	var out []Candidate
+
+	for _, m := range reStreetViewAt.FindAllStringSubmatch(text, -1) {
+		c := newCandidate("streetview", m[0], m[1], m[2])
+		if c == nil {
+			continue
+		}
+		view := &View{}
+		for _, param := range strings.Split(strings.TrimPrefix(m[3], ","), ",") {
+			if len(param) < 2 {
+				continue
+			}
+			v, err := strconv.ParseFloat(param[:len(param)-1], 64)
+			if err != nil {
+				continue
+			}
+			switch param[len(param)-1] {
+			case 'h':
+				view.Heading = v
+			case 't':
+				// Tilt runs from 0 (straight down) to 180 (straight up).
+				view.Pitch = v - 90
+			}
+		}
+		c.View = view
+		out = appendCandidate(out, *c)
+	}
+
+	for _, m := range reStreetViewAPI.FindAllString(text, -1) {
+		q := matchedQuery(m)
+		lat, lng, ok := strings.Cut(q.Get("viewpoint"), ",")
+		if !ok {
+			continue
+		}
+		c := newCandidate("streetview", m, lat, lng)
+		if c == nil {
+			continue
+		}
+		c.View = &View{Heading: queryFloat(q, "heading"), Pitch: queryFloat(q, "pitch")}
+		out = appendCandidate(out, *c)
+	}
+
+	for _, m := range reStreetViewCB.FindAllString(text, -1) {
+		q := matchedQuery(m)
+		lat, lng, ok := strings.Cut(q.Get("cbll"), ",")
+		if !ok {
+			continue
+		}
+		c := newCandidate("streetview", m, lat, lng)
+		if c == nil {
+			continue
+		}
+		// cbp=12,heading,,zoom,pitch where a positive pitch looks down.
+		if cbp := strings.Split(q.Get("cbp"), ","); len(cbp) >= 5 {
+			heading, _ := strconv.ParseFloat(cbp[1], 64)
+			pitch, _ := strconv.ParseFloat(cbp[4], 64)
+			if pitch != 0 {
+				pitch = -pitch
+			}
+			c.View = &View{Heading: heading, Pitch: pitch}
+		}
+		out = appendCandidate(out, *c)
+	}
+
+	return out
+}
+
+// extractYandexPanorama reads panorama[point]=lng,lat and
+// panorama[direction]=heading,pitch from Yandex Maps links.
+func extractYandexPanorama(text string) []Candidate {
+	var out []Candidate
+	for _, m := range reYandexPanorama.FindAllString(text, -1) {
+		q := matchedQuery(m)
+		lng, lat, ok := strings.Cut(q.Get("panorama[point]"), ",")
+		if !ok {
+			continue
+		}
+		c := newCandidate("yandex_panorama", m, lat, lng)
+		if c == nil {
+			continue
+		}
+		if heading, pitch, ok := strings.Cut(q.Get("panorama[direction]"), ","); ok {
+			h, _ := strconv.ParseFloat(heading, 64)
+			p, _ := strconv.ParseFloat(pitch, 64)
+			c.View = &View{Heading: h, Pitch: p}
+		}
+		out = appendCandidate(out, *c)
+	}
+	return out
+}
+
+// matchedQuery parses the query string of a link matched without its scheme.
+func matchedQuery(matched string) url.Values {
+	u, err := url.Parse("https://" + matched)
+	if err != nil {
+		return url.Values{}
+	}
+	return u.Query()
+}
+
+func queryFloat(q url.Values, key string) float64 {
+	v, _ := strconv.ParseFloat(q.Get(key), 64)
+	return v
+}
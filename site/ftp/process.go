@@ -2,84 +2,203 @@ package ftp
 
 import (
 	"context"
+	"iter"
 	"log"
-	"regexp"
 
 	"github.com/findthisplace.eu/db"
 	"github.com/findthisplace.eu/dirty"
+	"github.com/findthisplace.eu/settings"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var foundTag = regexp.MustCompile(`(?i)\[НАЙДЕНО]`)
+// processChunkSize is how many posts are processed per round trip, which keeps
+// memory flat no matter how many ids the iterator yields.
+const processChunkSize = 500
+
+// ExtractorVersion is stored on every extracted comment. Bump it whenever the
+// extractors change in a way that can find something new, so a run with
+// Options.Reextract knows which comments to run through them again.
+const ExtractorVersion = 1
+
+// Process extracts coordinates from the comments of the given posts and
+// rebuilds the posts and user statistics. Posts edited by an admin are left
+// alone. With opts.DryRun nothing is written and the summary carries a Diff
+// of what would have changed.
+func Process(ctx context.Context, store *db.DB, sm *settings.Manager, postIDs iter.Seq2[int, error], opts Options) (*Summary, error) {
+	chunk := make([]int, 0, processChunkSize)
+	summary := &Summary{}
+	if opts.DryRun {
+		summary.Diff = &Diff{}
+		log.Println("ftp.Process: dry run, nothing will be written")
+	}
+	if opts.Reextract {
+		log.Printf("ftp.Process: re-extracting comments older than extractor version %d", ExtractorVersion)
+	}
+	confirms := loadConfirmMatcher(ctx, sm)
+	rules := loadFoundRules(ctx, sm)
 
-func Process(ctx context.Context, store *db.DB, postIDs []int) error {
-	if err := processComments(ctx, store, postIDs); err != nil {
-		return err
+	// pending collects the rebuilt posts of a dry run for the user statistics.
+	var pending []pendingPost
+
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		comments, err := processComments(ctx, store, chunk, opts, summary)
+		if err != nil {
+			return err
+		}
+		posts, err := processPosts(ctx, store, chunk, confirms, rules, opts, comments, summary)
+		if err != nil {
+			return err
+		}
+		pending = append(pending, posts...)
+		summary.Posts += len(chunk)
+		log.Printf("ftp.Process: %d posts processed", summary.Posts)
+		chunk = chunk[:0]
+		return nil
 	}
-	log.Println("ftp.Process: comments completed")
 
-	if err := processPosts(ctx, store, postIDs); err != nil {
-		return err
+	for id, err := range postIDs {
+		if err != nil {
+			return nil, err
+		}
+		chunk = append(chunk, id)
+		if len(chunk) == processChunkSize {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
 	}
-	log.Println("ftp.Process: posts completed")
+	log.Println("ftp.Process: comments and posts completed")
 
-	if err := processUsers(ctx, store); err != nil {
-		return err
+	if err := processUsers(ctx, store, opts, pending, summary); err != nil {
+		return nil, err
 	}
 	log.Println("ftp.Process: users completed")
 
-	log.Println("ftp.Process: completed")
-	return nil
+	log.Printf("ftp.Process: completed, %d posts newly found, %d unfound, %d moved, %d with a new finder, %d comments extracted",
+		summary.NewlyFound, summary.Unfound, summary.Moved, summary.FinderChanged, summary.CommentsExtracted)
+	return summary, nil
 }
 
-func processPosts(ctx context.Context, store *db.DB, postIDs []int) error {
+// processPosts rebuilds ftp_posts for the given posts and adds how they
+// differ from the stored ones to summary. A dry run writes nothing and
+// returns the rebuilt posts instead; comments then holds the chunk's
+// re-extracted comments, which were not written either.
+func processPosts(ctx context.Context, store *db.DB, postIDs []int, confirms *confirmMatcher, rules *foundRules, opts Options, comments map[int]*FtpComment, summary *Summary) ([]pendingPost, error) {
 	topCommentByPost, err := loadTopComments(ctx, store, postIDs)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	candidatesByPost, err := loadPostCandidates(ctx, store, postIDs, comments)
+	if err != nil {
+		return nil, err
+	}
+
+	prevFound, err := loadFoundState(ctx, store, postIDs)
+	if err != nil {
+		return nil, err
 	}
 
-	coordsByPost, err := loadCommentCoords(ctx, store, postIDs)
+	markerDates, err := loadMarkerDates(ctx, store, postIDs, rules)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	cur, err := store.DirtyPosts.Find(ctx, bson.M{"_id": bson.M{"$in": postIDs}}, options.Find().
+	threads, err := loadThreads(ctx, store, postIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	cur, err := store.DirtyPosts.Find(ctx, bson.M{"_id": bson.M{"$in": postIDs}, "vanished": db.NotVanished}, options.Find().
 		SetBatchSize(500))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer cur.Close(ctx)
 
+	var pending []pendingPost
 	bulk := make([]mongo.WriteModel, 0, 500)
 	for cur.Next(ctx) {
 
 		var dp dirty.DirtyPost
 		if err := cur.Decode(&dp); err != nil {
-			return err
+			return nil, err
 		}
 
 		fp := &FtpPost{
 			Id:      dp.Id,
-			IsFound: foundTag.MatchString(dp.Text),
+			IsFound: rules.IsFound(&dp),
 		}
 
+		fp.Candidates = candidatesByPost[dp.Id]
+
 		if fp.IsFound {
-			if c, ok := coordsByPost[dp.Id]; ok {
+			confirmed, isConfirmed := attribute(dp.UserId, threads[dp.Id], confirms)
+			if isConfirmed {
+				fp.Candidates = preferComment(fp.Candidates, confirmed.CommentId)
+			}
+
+			// creditedId is the comment the find is credited to, if any.
+			var creditedId int
+			if len(fp.Candidates) > 0 {
 				// Credit the find to whoever posted the comment that located the
 				// place, not the highest-rated comment (which is often unrelated).
+				c := fp.Candidates[0]
 				fp.Latitude = c.Lat
 				fp.Longitude = c.Lng
 				fp.FoundById = c.UserId
 				fp.FoundDate = c.Created
+				fp.FoundDateSource = FoundDateComment
+				creditedId = c.CommentId
 			} else if tc, ok := topCommentByPost[dp.Id]; ok {
 				// No located comment; fall back to the top-rated comment's author.
 				fp.FoundById = tc.UserId
 				fp.FoundDate = tc.CreatedAt
+				fp.FoundDateSource = FoundDateTopComment
+			}
+
+			// The author saying "да!" to a comment settles who found the place,
+			// whatever the coordinates and ratings suggest.
+			if isConfirmed {
+				fp.FoundById = confirmed.UserId
+				fp.FoundDate = confirmed.Created
+				fp.FoundDateSource = FoundDateComment
+				fp.ConfirmationId = confirmed.ReplyId
+				creditedId = confirmed.CommentId
+			}
+
+			fp.Contributors = contributors(fp.FoundById, creditedId, dp.UserId, threads[dp.Id])
+
+			// The moment the author marked the post found beats any comment
+			// date, which may be long before or after the place was named.
+			if t, ok := markerDates[dp.Id]; ok {
+				fp.FoundDate = dirty.EpochTime{Time: t}
+				fp.FoundDateSource = FoundDateMarker
 			}
 		}
 
+		score(fp)
+
+		prev := prevFound[dp.Id]
+		if prev.ManualOverride {
+			summary.ManualSkipped++
+			continue
+		}
+		summary.addPost(diffPost(prev.PostState, fp))
+
+		if opts.DryRun {
+			pending = append(pending, pendingPost{post: fp, authorId: dp.UserId, created: dp.CreatedDate.Time})
+			continue
+		}
+
 		model := mongo.NewReplaceOneModel().
 			SetFilter(bson.M{"_id": fp.Id, "manual_override": bson.M{"$ne": true}}).
 			SetReplacement(fp).
@@ -89,57 +208,113 @@ func processPosts(ctx context.Context, store *db.DB, postIDs []int) error {
 
 		if len(bulk) == cap(bulk) {
 			if err := writeBulk(ctx, store, bulk); err != nil {
-				return err
+				return nil, err
 			}
 			bulk = bulk[:0]
 		}
 	}
 	if len(bulk) > 0 {
 		if err := writeBulk(ctx, store, bulk); err != nil {
-			return err
+			return nil, err
 		}
 		log.Printf("Updated %d posts to the database", len(bulk))
 	}
-	return cur.Err()
+	return pending, cur.Err()
+}
+
+// foundState is the part of a stored ftp_posts document that processPosts
+// compares against before replacing it.
+type foundState struct {
+	PostState      `bson:",inline"`
+	ManualOverride bool `bson:"manual_override"`
+}
+
+func loadFoundState(ctx context.Context, store *db.DB, postIDs []int) (map[int]foundState, error) {
+	cur, err := store.FtpPosts.Find(ctx,
+		bson.M{"_id": bson.M{"$in": postIDs}},
+		options.Find().SetProjection(bson.M{
+			"is_found": 1, "manual_override": 1, "latitude": 1, "longitude": 1, "found_by_id": 1,
+		}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	result := make(map[int]foundState)
+	for cur.Next(ctx) {
+		var row struct {
+			Id         int `bson:"_id"`
+			foundState `bson:",inline"`
+		}
+		if err := cur.Decode(&row); err != nil {
+			return nil, err
+		}
+		result[row.Id] = row.foundState
+	}
+	return result, cur.Err()
 }
 
-func processComments(ctx context.Context, store *db.DB, postIDs []int) error {
+// processComments extracts coordinates from the comments of the given posts.
+// Comments with coordinates already are skipped unless opts.Reextract is set,
+// in which case only those extracted with the current ExtractorVersion are.
+// A dry run writes nothing and returns the extracted comments instead.
+func processComments(ctx context.Context, store *db.DB, postIDs []int, opts Options, summary *Summary) (map[int]*FtpComment, error) {
 	commentIDs, err := loadCommentIDs(ctx, store, postIDs)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	existing, err := loadExtractedCommentIDs(ctx, store, commentIDs)
+	existing, err := loadCommentState(ctx, store, commentIDs)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	cur, err := store.DirtyComments.Find(ctx, bson.M{"post_id": bson.M{"$in": postIDs}},
+	cur, err := store.DirtyComments.Find(ctx, bson.M{"post_id": bson.M{"$in": postIDs}, "vanished": db.NotVanished},
 		options.Find().SetBatchSize(500))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer cur.Close(ctx)
 
+	// extracted only fills up in a dry run, standing in for the writes.
+	extracted := make(map[int]*FtpComment)
 	bulk := make([]mongo.WriteModel, 0, 500)
 	for cur.Next(ctx) {
 		var dc dirty.DirtyComment
 		if err := cur.Decode(&dc); err != nil {
-			return err
+			return nil, err
 		}
 
-		if existing[dc.Id] {
+		prev, seen := existing[dc.Id]
+		if opts.Reextract && seen && prev.Version >= ExtractorVersion {
+			continue
+		}
+		if !opts.Reextract && prev.Extracted {
 			continue
 		}
 
 		fc := &FtpComment{
-			Id: dc.Id,
+			Id:      dc.Id,
+			Version: ExtractorVersion,
 		}
 
-		if c := ExtractCoords(dc.Text); c != nil {
+		if cs := ExtractAll(ctx, store, dc.Text, opts.DryRun); len(cs) > 0 {
+			c := cs[0]
 			fc.Extracted = true
 			fc.Latitude = c.Lat
 			fc.Longitude = c.Lng
+			fc.Provider = c.Provider
+			fc.URL = c.URL
+			fc.Precision = c.Precision
+			fc.View = c.View
+			fc.Candidates = cs
+		}
+
+		summary.addComment(dc.PostId, &prev, fc)
+
+		if opts.DryRun {
+			extracted[fc.Id] = fc
+			continue
 		}
 
 		model := mongo.NewReplaceOneModel().
@@ -151,18 +326,18 @@ func processComments(ctx context.Context, store *db.DB, postIDs []int) error {
 
 		if len(bulk) == cap(bulk) {
 			if err := writeCommentBulk(ctx, store, bulk); err != nil {
-				return err
+				return nil, err
 			}
 			bulk = bulk[:0]
 		}
 	}
 	if len(bulk) > 0 {
 		if err := writeCommentBulk(ctx, store, bulk); err != nil {
-			return err
+			return nil, err
 		}
 		log.Printf("Updated %d comments to the database", len(bulk))
 	}
-	return cur.Err()
+	return extracted, cur.Err()
 }
 
 func loadCommentIDs(ctx context.Context, store *db.DB, postIDs []int) ([]int, error) {
@@ -187,35 +362,50 @@ func loadCommentIDs(ctx context.Context, store *db.DB, postIDs []int) ([]int, er
 	return ids, cur.Err()
 }
 
-// commentLocation is the coordinates a post inherits from a comment, plus the
-// author and date of that comment so the post can be credited to whoever
-// actually located the place.
-type commentLocation struct {
-	Lat, Lng float64
-	UserId   int
-	Created  dirty.EpochTime
-}
-
-func loadCommentCoords(ctx context.Context, store *db.DB, postIDs []int) (map[int]commentLocation, error) {
+// maxPostCandidates caps how many candidates are kept per post; past the
+// first few they are rarely more than noise.
+const maxPostCandidates = 10
+
+// loadPostCandidates ranks the coordinates found in each post's comments:
+// comments by rating, highest first, and within a comment in the order the
+// extractors found them. The first candidate is what the post inherits, and
+// it carries the comment's author and date so the find is credited to
+// whoever actually located the place.
+//
+// overlay replaces the stored ftp_comments of a dry run, which were
+// extracted again but not written.
+func loadPostCandidates(ctx context.Context, store *db.DB, postIDs []int, overlay map[int]*FtpComment) (map[int][]PostCandidate, error) {
 	pipeline := mongo.Pipeline{
-		{{Key: "$match", Value: bson.M{"post_id": bson.M{"$in": postIDs}}}},
+		{{Key: "$match", Value: bson.M{"post_id": bson.M{"$in": postIDs}, "vanished": db.NotVanished}}},
 		{{Key: "$lookup", Value: bson.D{
 			{Key: "from", Value: "ftp_comments"},
 			{Key: "localField", Value: "_id"},
 			{Key: "foreignField", Value: "_id"},
 			{Key: "as", Value: "ftp"},
 		}}},
-		{{Key: "$unwind", Value: "$ftp"}},
-		{{Key: "$match", Value: bson.M{"ftp.extracted": true}}},
-		{{Key: "$sort", Value: bson.D{{Key: "rating", Value: -1}}}},
-		{{Key: "$group", Value: bson.D{
-			{Key: "_id", Value: "$post_id"},
-			{Key: "lat", Value: bson.M{"$first": "$ftp.latitude"}},
-			{Key: "lng", Value: bson.M{"$first": "$ftp.longitude"}},
-			{Key: "user_id", Value: bson.M{"$first": "$user_id"}},
-			{Key: "created", Value: bson.M{"$first": "$created"}},
-		}}},
 	}
+	if len(overlay) == 0 {
+		pipeline = append(pipeline,
+			bson.D{{Key: "$unwind", Value: "$ftp"}},
+			bson.D{{Key: "$match", Value: bson.M{"ftp.extracted": true}}},
+		)
+	} else {
+		// Comments without stored coordinates may have some in the overlay.
+		pipeline = append(pipeline, bson.D{{Key: "$unwind", Value: bson.D{
+			{Key: "path", Value: "$ftp"},
+			{Key: "preserveNullAndEmptyArrays", Value: true},
+		}}})
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "rating", Value: -1}, {Key: "_id", Value: 1}}}},
+		bson.D{{Key: "$project", Value: bson.M{
+			"post_id": 1,
+			"user_id": 1,
+			"rating":  1,
+			"created": 1,
+			"ftp":     1,
+		}}},
+	)
 
 	cur, err := store.DirtyComments.Aggregate(ctx, pipeline)
 	if err != nil {
@@ -223,41 +413,80 @@ func loadCommentCoords(ctx context.Context, store *db.DB, postIDs []int) (map[in
 	}
 	defer cur.Close(ctx)
 
-	result := make(map[int]commentLocation)
+	result := make(map[int][]PostCandidate)
 	for cur.Next(ctx) {
 		var row struct {
-			PostId  int             `bson:"_id"`
-			Lat     float64         `bson:"lat"`
-			Lng     float64         `bson:"lng"`
+			Id      int             `bson:"_id"`
+			PostId  int             `bson:"post_id"`
 			UserId  int             `bson:"user_id"`
+			Rating  int             `bson:"rating"`
 			Created dirty.EpochTime `bson:"created"`
+			Ftp     FtpComment      `bson:"ftp"`
 		}
 		if err := cur.Decode(&row); err != nil {
 			return nil, err
 		}
-		result[row.PostId] = commentLocation{Lat: row.Lat, Lng: row.Lng, UserId: row.UserId, Created: row.Created}
+		if fc, ok := overlay[row.Id]; ok {
+			row.Ftp = *fc
+		}
+		if !row.Ftp.Extracted {
+			continue
+		}
+
+		found := row.Ftp.Candidates
+		if len(found) == 0 {
+			// Extracted before comments kept every candidate.
+			found = []Candidate{*commentCandidate(&row.Ftp)}
+		}
+
+		cands := result[row.PostId]
+		for _, c := range found {
+			if len(cands) == maxPostCandidates {
+				break
+			}
+			if hasCandidate(cands, c) {
+				continue
+			}
+			cands = append(cands, PostCandidate{
+				Candidate: c,
+				CommentId: row.Id,
+				UserId:    row.UserId,
+				Rating:    row.Rating,
+				Created:   row.Created,
+			})
+		}
+		result[row.PostId] = cands
 	}
 	return result, cur.Err()
 }
 
-func loadExtractedCommentIDs(ctx context.Context, store *db.DB, commentIDs []int) (map[int]bool, error) {
+func hasCandidate(cands []PostCandidate, c Candidate) bool {
+	for _, x := range cands {
+		if x.Lat == c.Lat && x.Lng == c.Lng {
+			return true
+		}
+	}
+	return false
+}
+
+// loadCommentState returns the stored extraction state of the given comments,
+// without their candidates.
+func loadCommentState(ctx context.Context, store *db.DB, commentIDs []int) (map[int]FtpComment, error) {
 	cur, err := store.FtpComments.Find(ctx,
-		bson.M{"_id": bson.M{"$in": commentIDs}, "extracted": true},
-		options.Find().SetProjection(bson.M{"_id": 1}))
+		bson.M{"_id": bson.M{"$in": commentIDs}},
+		options.Find().SetProjection(bson.M{"candidates": 0}))
 	if err != nil {
 		return nil, err
 	}
 	defer cur.Close(ctx)
 
-	result := make(map[int]bool)
+	result := make(map[int]FtpComment)
 	for cur.Next(ctx) {
-		var row struct {
-			Id int `bson:"_id"`
-		}
+		var row FtpComment
 		if err := cur.Decode(&row); err != nil {
 			return nil, err
 		}
-		result[row.Id] = true
+		result[row.Id] = row
 	}
 	return result, cur.Err()
 }
@@ -282,7 +511,7 @@ type topComment struct {
 
 func loadTopComments(ctx context.Context, store *db.DB, postIDs []int) (map[int]topComment, error) {
 	pipeline := mongo.Pipeline{
-		{{Key: "$match", Value: bson.M{"post_id": bson.M{"$in": postIDs}}}},
+		{{Key: "$match", Value: bson.M{"post_id": bson.M{"$in": postIDs}, "vanished": db.NotVanished}}},
 		{{Key: "$sort", Value: bson.D{{Key: "rating", Value: -1}}}},
 		{{Key: "$group", Value: bson.D{
 			{Key: "_id", Value: "$post_id"},
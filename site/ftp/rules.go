@@ -0,0 +1,168 @@
+package ftp
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/findthisplace.eu/dirty"
+	"github.com/findthisplace.eu/settings"
+)
+
+// DefaultFoundRules is used while the found_rules setting is unset: a post
+// is found once its text carries the [НАЙДЕНО] marker.
+func DefaultFoundRules() *settings.FoundRuleSet {
+	return &settings.FoundRuleSet{
+		Found: []settings.FoundRule{{TextPattern: `(?i)\[НАЙДЕНО]`}},
+	}
+}
+
+// LoadFoundRules returns the rule set from settings, falling back to
+// DefaultFoundRules while the setting is unset or invalid.
+func LoadFoundRules(ctx context.Context, sm *settings.Manager) *settings.FoundRuleSet {
+	if sm != nil {
+		if rs, err := sm.GetFoundRules(ctx); err == nil {
+			return rs
+		}
+	}
+	return DefaultFoundRules()
+}
+
+// foundRule is a settings.FoundRule with its patterns compiled.
+type foundRule struct {
+	settings.FoundRule
+	text  *regexp.Regexp
+	title *regexp.Regexp
+}
+
+// foundRules is a compiled settings.FoundRuleSet.
+type foundRules struct {
+	found    []foundRule
+	notFound []foundRule
+}
+
+func compileFoundRules(rs *settings.FoundRuleSet) (*foundRules, error) {
+	if err := rs.Check(); err != nil {
+		return nil, err
+	}
+	compile := func(rules []settings.FoundRule) []foundRule {
+		out := make([]foundRule, len(rules))
+		for i, r := range rules {
+			out[i] = foundRule{FoundRule: r}
+			// Check has already compiled every pattern once.
+			if r.TextPattern != "" {
+				out[i].text = regexp.MustCompile(r.TextPattern)
+			}
+			if r.TitlePattern != "" {
+				out[i].title = regexp.MustCompile(r.TitlePattern)
+			}
+		}
+		return out
+	}
+	return &foundRules{found: compile(rs.Found), notFound: compile(rs.NotFound)}, nil
+}
+
+// loadFoundRules compiles the rule set from settings. An invalid rule set can
+// only get there by editing the database directly, so it is logged and the
+// default used.
+func loadFoundRules(ctx context.Context, sm *settings.Manager) *foundRules {
+	rules, err := compileFoundRules(LoadFoundRules(ctx, sm))
+	if err != nil {
+		log.Printf("ftp.Process: found rules: %v, using the default", err)
+		rules, _ = compileFoundRules(DefaultFoundRules())
+	}
+	return rules
+}
+
+func (r *foundRule) match(dp *dirty.DirtyPost) bool {
+	if r.text != nil && !r.text.MatchString(dp.Text) {
+		return false
+	}
+	if r.title != nil && !r.title.MatchString(dp.Title) {
+		return false
+	}
+	if r.Tag != "" && !hasTag(dp.Tags, r.Tag) {
+		return false
+	}
+	if r.NoTag != "" && hasTag(dp.Tags, r.NoTag) {
+		return false
+	}
+	if r.Golden != nil && *r.Golden != dp.IsGolden {
+		return false
+	}
+	return true
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(strings.TrimSpace(t), strings.TrimSpace(tag)) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFound reports whether dp matches a found rule and no not-found rule.
+func (rs *foundRules) IsFound(dp *dirty.DirtyPost) bool {
+	found := false
+	for i := range rs.found {
+		if rs.found[i].match(dp) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+	for i := range rs.notFound {
+		if rs.notFound[i].match(dp) {
+			return false
+		}
+	}
+	return true
+}
+
+// Marked reports whether text carries a found marker, i.e. matches the text
+// pattern of a found rule. It dates the find from the revision history, which
+// records text edits only.
+func (rs *foundRules) Marked(text string) bool {
+	for _, r := range rs.found {
+		if r.text != nil && r.text.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// RuleMatch tells whether one rule of a set matched a post.
+type RuleMatch struct {
+	// List is "found" or "not_found", Index the rule's position in it.
+	List    string             `json:"list"`
+	Index   int                `json:"index"`
+	Rule    settings.FoundRule `json:"rule"`
+	Matched bool               `json:"matched"`
+}
+
+// RuleResult is how a rule set judges a post.
+type RuleResult struct {
+	IsFound bool        `json:"is_found"`
+	Rules   []RuleMatch `json:"rules"`
+}
+
+// EvaluateFoundRules runs rs against dp rule by rule, so a rule set can be
+// tried out before it is saved.
+func EvaluateFoundRules(rs *settings.FoundRuleSet, dp *dirty.DirtyPost) (*RuleResult, error) {
+	rules, err := compileFoundRules(rs)
+	if err != nil {
+		return nil, err
+	}
+	res := &RuleResult{IsFound: rules.IsFound(dp)}
+	for i := range rules.found {
+		res.Rules = append(res.Rules, RuleMatch{List: "found", Index: i, Rule: rules.found[i].FoundRule, Matched: rules.found[i].match(dp)})
+	}
+	for i := range rules.notFound {
+		res.Rules = append(res.Rules, RuleMatch{List: "not_found", Index: i, Rule: rules.notFound[i].FoundRule, Matched: rules.notFound[i].match(dp)})
+	}
+	return res, nil
+}
@@ -0,0 +1,69 @@
+package ftp
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/findthisplace.eu/db"
+)
+
+// Retry times for short links that could not be resolved. Failures are
+// usually transient, so they are retried soon with a growing delay; links
+// that resolve without coordinates rarely change and wait much longer.
+const (
+	shortURLRetryBase     = time.Hour
+	shortURLRetryMax      = 7 * 24 * time.Hour
+	shortURLNoCoordsRetry = 30 * 24 * time.Hour
+)
+
+func loadShortURL(ctx context.Context, store *db.DB, shortURL string) *db.ShortURL {
+	if store == nil {
+		return nil
+	}
+	su, err := store.LoadShortURL(ctx, shortURL)
+	if err != nil {
+		log.Printf("geo: could not read cache for %s: %v", shortURL, err)
+		return nil
+	}
+	return su
+}
+
+// saveShortURL records the outcome of walking shortURL. prev is the entry
+// being replaced, if any, and carries the attempt count forward.
+func saveShortURL(ctx context.Context, store *db.DB, prev *db.ShortURL, shortURL, final, status string, c *Candidate, walkErr error) {
+	if store == nil {
+		return
+	}
+
+	now := time.Now()
+	su := &db.ShortURL{
+		URL:      shortURL,
+		Status:   status,
+		FinalURL: final,
+		Attempts: 1,
+		Checked:  now,
+	}
+	if prev != nil {
+		su.Attempts = prev.Attempts + 1
+	}
+	if c != nil {
+		su.Lat = c.Lat
+		su.Lng = c.Lng
+	}
+	if walkErr != nil {
+		su.Error = walkErr.Error()
+	}
+
+	switch status {
+	case db.ShortURLFailed:
+		delay := shortURLRetryBase << min(su.Attempts-1, 10)
+		su.RetryAt = now.Add(min(delay, shortURLRetryMax))
+	case db.ShortURLNoCoords:
+		su.RetryAt = now.Add(shortURLNoCoordsRetry)
+	}
+
+	if err := store.SaveShortURL(ctx, su); err != nil {
+		log.Printf("geo: could not cache %s: %v", shortURL, err)
+	}
+}
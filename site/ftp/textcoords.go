@@ -0,0 +1,280 @@
+package ftp
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// textExtractor finds coordinates typed straight into a comment rather than
+// carried in a map link: decimal pairs such as "48.8584, 2.2945", degrees
+// with decimal minutes such as "48°51.5'N 2°17.67'E" and full DMS such as
+// 48°51'30"N 2°17'40"E, including the Russian с.ш./в.д. hemisphere forms.
+//
+// Plain numbers show up in comments for all kinds of reasons, so it only
+// accepts shapes that are hard to produce by accident:
+//   - links are cut out first, as the provider extractors read those;
+//   - decimal pairs need at least four decimal places on both numbers and a
+//     comma, semicolon or hemisphere letters between them, which rules out
+//     versions and dates;
+//   - decimal pairs with neither a degree sign nor a hemisphere letter are
+//     dropped if they look like prices: both padded with trailing zeros, as
+//     in "12.5000, 3.9900", or next to a currency;
+//   - numbers glued to other digits or dots are skipped, so "1.2.3.4" or
+//     "12.05.2014" never yield a half;
+//   - degree pairs need hemisphere letters or minutes on both halves, so
+//     temperatures such as "5°, 10°" do not count;
+//   - 0,0 and anything out of range is dropped.
+type textExtractor struct{}
+
+func (textExtractor) Name() string { return "text" }
+
+var reLink = regexp.MustCompile(`https?://[^\s"'<>]+`)
+
+var reDecimalPair = regexp.MustCompile(
+	`(-?\d{1,2}\.\d{4,})\s*°?\s*(?:([NS])\b)?\s*([,;]?)\s*(-?\d{1,3}\.\d{4,})\s*°?\s*(?:([EW])\b)?`)
+
+// reDegrees matches one degree-based coordinate: decimal degrees, degrees
+// with decimal minutes, or degrees, minutes and seconds, with an optional
+// hemisphere after it.
+var reDegrees = regexp.MustCompile(
+	`(\d{1,3}(?:[.,]\d+)?)\s*[°º]\s*` +
+		`(?:(\d{1,2}(?:[.,]\d+)?)\s*['′’]\s*` +
+		`(?:(\d{1,2}(?:[.,]\d+)?)\s*(?:"|″|”|'')\s*)?)?` +
+		`(?:([NSEW])\b|([сС]\.?\s?ш\.?|[юЮ]\.?\s?ш\.?|[вВ]\.?\s?д\.?|[зЗ]\.?\s?д\.?))?`)
+
+func (textExtractor) Extract(text string) []Candidate {
+	text = reLink.ReplaceAllString(text, " ")
+
+	var out []Candidate
+	for _, c := range extractDecimalPairs(text) {
+		out = appendCandidate(out, c)
+	}
+	for _, c := range extractDegreePairs(text) {
+		out = appendCandidate(out, c)
+	}
+	return out
+}
+
+func extractDecimalPairs(text string) []Candidate {
+	var out []Candidate
+	for _, m := range reDecimalPair.FindAllStringSubmatchIndex(text, -1) {
+		end := trimEnd(text, m[0], m[1])
+		if !isolated(text, m[0], end) {
+			continue
+		}
+		latStr, latHemi := text[m[2]:m[3]], group(text, m, 2)
+		sep := group(text, m, 3)
+		lngStr, lngHemi := text[m[8]:m[9]], group(text, m, 5)
+		if sep == "" && (latHemi == "" || lngHemi == "") {
+			continue
+		}
+
+		marked := latHemi != "" || lngHemi != "" || strings.ContainsRune(text[m[0]:end], '°')
+		if !marked && priceLike(text, m[0], end, latStr, lngStr) {
+			continue
+		}
+
+		c := newCandidate("text", text[m[0]:end], latStr, lngStr)
+		if c == nil || c.Lat == 0 && c.Lng == 0 {
+			continue
+		}
+		if latHemi == "S" {
+			c.Lat = -c.Lat
+		}
+		if lngHemi == "W" {
+			c.Lng = -c.Lng
+		}
+		out = append(out, *c)
+	}
+	return out
+}
+
+// reCurrency matches the currency signs and words found around prices.
+var reCurrency = regexp.MustCompile(`(?i)[$€£₽¥]|\b(?:usd|eur|rub)\b|(?:^|[^\p{L}])(?:руб|р\.|грн|евро|доллар|цен[аеуы])`)
+
+// currencyWindow is how many runes either side of a pair are searched for a
+// currency.
+const currencyWindow = 12
+
+// priceLike reports whether the pair text[start:end] reads as prices rather
+// than coordinates.
+func priceLike(text string, start, end int, lat, lng string) bool {
+	if strings.HasSuffix(lat, "00") && strings.HasSuffix(lng, "00") {
+		return true
+	}
+	before := []rune(text[:start])
+	after := []rune(text[end:])
+	around := string(before[max(0, len(before)-currencyWindow):]) + " " +
+		string(after[:min(len(after), currencyWindow)])
+	return reCurrency.MatchString(around)
+}
+
+// degrees is one half of a degree-based pair.
+type degrees struct {
+	value      float64
+	hemisphere byte // 'N', 'S', 'E', 'W' or 0
+	minutes    bool
+	precision  int
+	start, end int
+}
+
+func extractDegreePairs(text string) []Candidate {
+	var parts []degrees
+	for _, m := range reDegrees.FindAllStringSubmatchIndex(text, -1) {
+		if d, ok := parseDegrees(text, m); ok {
+			parts = append(parts, d)
+		}
+	}
+
+	var out []Candidate
+	for i := 0; i+1 < len(parts); i++ {
+		a, b := parts[i], parts[i+1]
+		if strings.Trim(text[a.end:b.start], " \t\r\n,;") != "" {
+			continue
+		}
+
+		lat, lng := a, b
+		if isLongitude(a.hemisphere) && isLatitude(b.hemisphere) {
+			lat, lng = b, a
+		}
+		hemispheres := isLatitude(lat.hemisphere) && isLongitude(lng.hemisphere)
+		unmarked := lat.hemisphere == 0 && lng.hemisphere == 0 && lat.minutes && lng.minutes
+		if !hemispheres && !unmarked {
+			continue
+		}
+
+		latV, lngV := lat.value, lng.value
+		if lat.hemisphere == 'S' {
+			latV = -latV
+		}
+		if lng.hemisphere == 'W' {
+			lngV = -lngV
+		}
+		if latV > 90 || latV < -90 || lngV > 180 || lngV < -180 || latV == 0 && lngV == 0 {
+			continue
+		}
+
+		out = append(out, Candidate{
+			Provider:  "text",
+			URL:       strings.TrimSpace(text[a.start:b.end]),
+			Lat:       latV,
+			Lng:       lngV,
+			Precision: min(lat.precision, lng.precision),
+		})
+		i++
+	}
+	return out
+}
+
+func parseDegrees(text string, m []int) (degrees, bool) {
+	d := degrees{start: m[0], end: trimEnd(text, m[0], m[1])}
+	if !isolated(text, d.start, d.end) {
+		return d, false
+	}
+
+	deg, ok := parseNumber(group(text, m, 1))
+	if !ok {
+		return d, false
+	}
+	d.value = deg
+	d.precision = decimals(strings.ReplaceAll(group(text, m, 1), ",", "."))
+
+	if s := group(text, m, 2); s != "" {
+		if deg != float64(int(deg)) {
+			return d, false
+		}
+		minutes, ok := parseNumber(s)
+		if !ok || minutes >= 60 {
+			return d, false
+		}
+		d.value += minutes / 60
+		d.minutes = true
+		d.precision = 2 + decimals(strings.ReplaceAll(s, ",", "."))
+
+		if s := group(text, m, 3); s != "" {
+			if minutes != float64(int(minutes)) {
+				return d, false
+			}
+			seconds, ok := parseNumber(s)
+			if !ok || seconds >= 60 {
+				return d, false
+			}
+			d.value += seconds / 3600
+			d.precision = 4 + decimals(strings.ReplaceAll(s, ",", "."))
+		}
+	}
+
+	d.hemisphere = hemisphere(group(text, m, 4) + group(text, m, 5))
+	return d, true
+}
+
+// hemisphere maps a hemisphere suffix, Latin or Russian, to N, S, E or W.
+func hemisphere(s string) byte {
+	if s == "" {
+		return 0
+	}
+	r, _ := utf8.DecodeRuneInString(s)
+	switch r {
+	case 'N', 'с', 'С':
+		return 'N'
+	case 'S', 'ю', 'Ю':
+		return 'S'
+	case 'E', 'в', 'В':
+		return 'E'
+	case 'W', 'з', 'З':
+		return 'W'
+	}
+	return 0
+}
+
+func isLatitude(h byte) bool  { return h == 'N' || h == 'S' }
+func isLongitude(h byte) bool { return h == 'E' || h == 'W' }
+
+func parseNumber(s string) (float64, bool) {
+	f, err := strconv.ParseFloat(strings.ReplaceAll(s, ",", "."), 64)
+	return f, err == nil
+}
+
+// trimEnd drops the trailing whitespace a match may have swallowed while
+// looking for an optional hemisphere.
+func trimEnd(text string, start, end int) int {
+	return start + len(strings.TrimRight(text[start:end], " \t\r\n"))
+}
+
+// isolated reports whether text[start:end] is not glued to surrounding
+// digits, dots or letters, which would make it part of a longer token.
+func isolated(text string, start, end int) bool {
+	if start > 0 {
+		r, _ := utf8.DecodeLastRuneInString(text[:start])
+		if isTokenRune(r) {
+			return false
+		}
+	}
+	if end < len(text) {
+		r, size := utf8.DecodeRuneInString(text[end:])
+		// A dot is fine at the end of a sentence but not before more digits.
+		if r == '.' {
+			r, _ = utf8.DecodeRuneInString(text[end+size:])
+			if r >= '0' && r <= '9' {
+				return false
+			}
+		} else if isTokenRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func isTokenRune(r rune) bool {
+	return r == '.' || r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+}
+
+// group returns submatch n of m, or "" if it did not participate.
+func group(text string, m []int, n int) string {
+	if m[2*n] < 0 {
+		return ""
+	}
+	return text[m[2*n]:m[2*n+1]]
+}
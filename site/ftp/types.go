@@ -7,28 +7,99 @@ type FtpComment struct {
 	Extracted bool    `bson:"extracted"`
 	Longitude float64 `bson:"longitude,omitempty"`
 	Latitude  float64 `bson:"latitude,omitempty"`
+	Provider  string  `bson:"provider,omitempty"`
+	URL       string  `bson:"url,omitempty"`
+	Precision int     `bson:"precision,omitempty"`
+	View      *View   `bson:"view,omitempty"`
+	// Version is the ExtractorVersion the comment was last extracted with.
+	Version int `bson:"extractor_version,omitempty"`
+	// Candidates holds every set of coordinates found in the comment, the
+	// first of which is also stored in the fields above.
+	Candidates []Candidate `bson:"candidates,omitempty"`
 }
 
 type FtpPost struct {
-	Id             int             `json:"id" bson:"_id"`
-	IsFound        bool            `bson:"is_found"`
-	Longitude      float64         `bson:"longitude,omitempty"`
-	Latitude       float64         `bson:"latitude,omitempty"`
-	FoundById      int             `bson:"found_by_id,omitempty"`
-	FoundDate      dirty.EpochTime `bson:"found_date,omitempty"`
-	ManualOverride bool            `bson:"manual_override,omitempty"`
+	Id        int             `json:"id" bson:"_id"`
+	IsFound   bool            `bson:"is_found"`
+	Longitude float64         `bson:"longitude,omitempty"`
+	Latitude  float64         `bson:"latitude,omitempty"`
+	FoundById int             `bson:"found_by_id,omitempty"`
+	FoundDate dirty.EpochTime `bson:"found_date,omitempty"`
+	// FoundDateSource says where FoundDate came from, one of the
+	// FoundDate* constants.
+	FoundDateSource string `bson:"found_date_source,omitempty"`
+	// ConfirmationId is the author's reply confirming the finder's comment,
+	// if the finder was credited that way.
+	ConfirmationId int `bson:"confirmation_id,omitempty"`
+	// Contributors shares the credit for the find, the finder first.
+	Contributors   []Contributor `bson:"contributors,omitempty"`
+	ManualOverride bool          `bson:"manual_override,omitempty"`
+	// Candidates ranks the coordinates found in the post's comments, best
+	// first, for an admin to choose from when the first one is wrong.
+	Candidates []PostCandidate `bson:"candidates,omitempty"`
+	// Confidence rates the location and finder from 0 to 1; Reasons lists
+	// the Reason* codes behind it.
+	Confidence float64  `bson:"confidence"`
+	Reasons    []string `bson:"reasons,omitempty"`
 }
 
+// PostCandidate is a Candidate together with the comment it came from.
+type PostCandidate struct {
+	Candidate `bson:",inline"`
+	CommentId int             `bson:"comment_id" json:"comment_id"`
+	UserId    int             `bson:"user_id" json:"user_id"`
+	Rating    int             `bson:"rating" json:"rating"`
+	Created   dirty.EpochTime `bson:"created" json:"created"`
+}
+
+const (
+	// FoundDateMarker is the grab at which the post text gained [НАЙДЕНО].
+	FoundDateMarker = "marker"
+	// FoundDateComment is the comment the coordinates were taken from.
+	FoundDateComment = "comment"
+	// FoundDateTopComment is the highest-rated comment on the post.
+	FoundDateTopComment = "top_comment"
+	// FoundDateManual is a date set by an admin.
+	FoundDateManual = "manual"
+)
+
 type FtpUser struct {
 	Id               int     `json:"id" bson:"_id"`
-	AuthorPostsFound int     `bson:"author_posts_found"`
-	AuthorPostsTotal int     `bson:"author_posts_total"`
-	FoundTiersTotal  int     `bson:"found_tiers_total"`
-	FoundTier0       int     `bson:"found_tier0"`
-	FoundTier1       int     `bson:"found_tier1"`
-	FoundTier2       int     `bson:"found_tier2"`
-	FoundTier3       int     `bson:"found_tier3"`
-	FoundTier4       int     `bson:"found_tier4"`
-	AvgSearchTime    float64 `bson:"avg_search_time"`
-	AvgAuthorTime    float64 `bson:"avg_author_time"`
+	AuthorPostsFound int     `json:"author_posts_found" bson:"author_posts_found"`
+	AuthorPostsTotal int     `json:"author_posts_total" bson:"author_posts_total"`
+	FoundTiersTotal  int     `json:"found_tiers_total" bson:"found_tiers_total"`
+	FoundTier0       int     `json:"found_tier0" bson:"found_tier0"`
+	FoundTier1       int     `json:"found_tier1" bson:"found_tier1"`
+	FoundTier2       int     `json:"found_tier2" bson:"found_tier2"`
+	FoundTier3       int     `json:"found_tier3" bson:"found_tier3"`
+	FoundTier4       int     `json:"found_tier4" bson:"found_tier4"`
+	AvgSearchTime    float64 `json:"avg_search_time" bson:"avg_search_time"`
+	AvgAuthorTime    float64 `json:"avg_author_time" bson:"avg_author_time"`
+	// FoundWeighted is the user's share of finds summed over contributor
+	// weights, hints included.
+	FoundWeighted float64 `json:"found_weighted" bson:"found_weighted"`
+}
+
+// Summary reports what a Process call changed.
+type Summary struct {
+	Posts      int `json:"posts"`
+	NewlyFound int `json:"newly_found"`
+	// Unfound counts posts that were found before and no longer are.
+	Unfound int `json:"unfound"`
+	// Moved counts posts found before and after whose coordinates changed.
+	Moved int `json:"moved"`
+	// FinderChanged counts posts found before and after credited to
+	// someone else.
+	FinderChanged int `json:"finder_changed"`
+	// ManualSkipped counts posts left alone because an admin edited them.
+	ManualSkipped int `json:"manual_skipped"`
+
+	// CommentsExtracted counts comments run through the extractors, and
+	// CommentsMoved those whose first coordinates changed as a result.
+	CommentsExtracted int `json:"comments_extracted"`
+	CommentsMoved     int `json:"comments_moved"`
+
+	// UsersChanged and Diff are only filled in by a dry run.
+	UsersChanged int   `json:"users_changed,omitempty"`
+	Diff         *Diff `json:"diff,omitempty"`
 }
@@ -19,20 +19,27 @@ var tierBounds = []float64{
 	(5 * 365 * 24 * 3600),         // tier3: 2 – 5 years
 }
 
-func processUsers(ctx context.Context, store *db.DB) error {
+// processUsers recomputes every user's statistics. In a dry run pending holds
+// the posts that were rebuilt but not written, which stand in for their
+// stored versions, and the changes go to summary instead of ftp_users.
+func processUsers(ctx context.Context, store *db.DB, opts Options, pending []pendingPost, summary *Summary) error {
 	users, err := loadAllUserIDs(ctx, store)
 	if err != nil {
 		return err
 	}
 	log.Printf("ftp.processUsers: loaded %d users from dirty_users", len(users))
 
-	if err := calcAuthorStats(ctx, store, users); err != nil {
+	if err := calcAuthorStats(ctx, store, users, pending); err != nil {
 		return err
 	}
-	if err := calcFinderStats(ctx, store, users); err != nil {
+	if err := calcFinderStats(ctx, store, users, pending); err != nil {
 		return err
 	}
 
+	if opts.DryRun {
+		return diffUsers(ctx, store, users, summary)
+	}
+
 	if len(users) == 0 {
 		return nil
 	}
@@ -88,8 +95,14 @@ func getOrCreate(users map[int]*FtpUser, id int) *FtpUser {
 
 // calcAuthorStats computes per-author totals and average time-to-find.
 // Joins dirty_posts with ftp_posts to get found status and found_date.
-func calcAuthorStats(ctx context.Context, store *db.DB, users map[int]*FtpUser) error {
+// pending posts are counted from memory instead.
+func calcAuthorStats(ctx context.Context, store *db.DB, users map[int]*FtpUser, pending []pendingPost) error {
+	match := bson.M{"vanished": db.NotVanished}
+	if len(pending) > 0 {
+		match["_id"] = bson.M{"$nin": pendingIDs(pending)}
+	}
 	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
 		// join with ftp_posts to get is_found and found_date
 		{{Key: "$lookup", Value: bson.D{
 			{Key: "from", Value: "ftp_posts"},
@@ -110,7 +123,8 @@ func calcAuthorStats(ctx context.Context, store *db.DB, users map[int]*FtpUser)
 					1, 0,
 				},
 			}}},
-			{Key: "avg_author_time", Value: bson.M{"$avg": bson.M{
+			// summed and counted rather than averaged, so pending posts can be added
+			{Key: "author_time_sum", Value: bson.M{"$sum": bson.M{
 				"$cond": bson.A{
 					bson.M{"$and": bson.A{
 						bson.M{"$ifNull": bson.A{"$ftp.is_found", false}},
@@ -120,7 +134,16 @@ func calcAuthorStats(ctx context.Context, store *db.DB, users map[int]*FtpUser)
 						bson.M{"$subtract": bson.A{"$ftp.found_date", "$created"}},
 						1000, // ms to seconds
 					}},
-					"$$REMOVE",
+					0,
+				},
+			}}},
+			{Key: "author_time_count", Value: bson.M{"$sum": bson.M{
+				"$cond": bson.A{
+					bson.M{"$and": bson.A{
+						bson.M{"$ifNull": bson.A{"$ftp.is_found", false}},
+						bson.M{"$gt": bson.A{"$ftp.found_date", nil}},
+					}},
+					1, 0,
 				},
 			}}},
 		}}},
@@ -132,36 +155,74 @@ func calcAuthorStats(ctx context.Context, store *db.DB, users map[int]*FtpUser)
 	}
 	defer cur.Close(ctx)
 
+	type authorAcc struct {
+		total, found int
+		timeSum      float64
+		timeCount    int
+	}
+	accs := make(map[int]*authorAcc)
+
 	for cur.Next(ctx) {
 		var row struct {
-			UserId       int      `bson:"_id"`
-			Total        int      `bson:"total"`
-			Found        int      `bson:"found"`
-			AvgAuthorTime *float64 `bson:"avg_author_time"`
+			UserId    int     `bson:"_id"`
+			Total     int     `bson:"total"`
+			Found     int     `bson:"found"`
+			TimeSum   float64 `bson:"author_time_sum"`
+			TimeCount int     `bson:"author_time_count"`
 		}
 		if err := cur.Decode(&row); err != nil {
 			return err
 		}
-		u := getOrCreate(users, row.UserId)
-		u.AuthorPostsTotal = row.Total
-		u.AuthorPostsFound = row.Found
-		if row.AvgAuthorTime != nil {
-			u.AvgAuthorTime = *row.AvgAuthorTime
+		accs[row.UserId] = &authorAcc{total: row.Total, found: row.Found, timeSum: row.TimeSum, timeCount: row.TimeCount}
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range pending {
+		acc, ok := accs[p.authorId]
+		if !ok {
+			acc = &authorAcc{}
+			accs[p.authorId] = acc
+		}
+		acc.total++
+		if p.post.IsFound {
+			acc.found++
+			if !p.post.FoundDate.IsZero() {
+				acc.timeSum += p.post.FoundDate.Sub(p.created).Seconds()
+				acc.timeCount++
+			}
+		}
+	}
+
+	for uid, acc := range accs {
+		u := getOrCreate(users, uid)
+		u.AuthorPostsTotal = acc.total
+		u.AuthorPostsFound = acc.found
+		if acc.timeCount > 0 {
+			u.AvgAuthorTime = acc.timeSum / float64(acc.timeCount)
 		}
 	}
-	return cur.Err()
+	return nil
 }
 
-// calcFinderStats computes per-finder tier counts and average search time.
+// calcFinderStats computes per-finder tier counts and average search time,
+// which credit the primary finder only, and the weighted share of finds, which
+// credits every contributor.
 // Uses ftp_posts joined with dirty_posts to get creation time for tier calculation.
-func calcFinderStats(ctx context.Context, store *db.DB, users map[int]*FtpUser) error {
+// pending posts are counted from memory instead.
+func calcFinderStats(ctx context.Context, store *db.DB, users map[int]*FtpUser, pending []pendingPost) error {
+	// only found posts with a finder
+	match := bson.M{
+		"is_found":    true,
+		"found_by_id": bson.M{"$gt": 0},
+		"found_date":  bson.M{"$ne": nil},
+	}
+	if len(pending) > 0 {
+		match["_id"] = bson.M{"$nin": pendingIDs(pending)}
+	}
 	pipeline := mongo.Pipeline{
-		// only found posts with a finder
-		{{Key: "$match", Value: bson.M{
-			"is_found":    true,
-			"found_by_id": bson.M{"$gt": 0},
-			"found_date":  bson.M{"$ne": nil},
-		}}},
+		{{Key: "$match", Value: match}},
 		// join with dirty_posts to get created date
 		{{Key: "$lookup", Value: bson.D{
 			{Key: "from", Value: "dirty_posts"},
@@ -170,6 +231,7 @@ func calcFinderStats(ctx context.Context, store *db.DB, users map[int]*FtpUser)
 			{Key: "as", Value: "dp"},
 		}}},
 		{{Key: "$unwind", Value: "$dp"}},
+		{{Key: "$match", Value: bson.M{"dp.vanished": db.NotVanished}}},
 		// compute search_time in seconds
 		{{Key: "$addFields", Value: bson.M{
 			"search_time": bson.M{"$divide": bson.A{
@@ -191,37 +253,57 @@ func calcFinderStats(ctx context.Context, store *db.DB, users map[int]*FtpUser)
 		tiers     [5]int
 	}
 	accs := make(map[int]*finderAcc)
+	weighted := make(map[int]float64)
 
-	for cur.Next(ctx) {
-		var row struct {
-			FoundById  int       `bson:"found_by_id"`
-			FoundDate  time.Time `bson:"found_date"`
-			SearchTime float64   `bson:"search_time"`
-			Dp         struct {
-				Created time.Time `bson:"created"`
-			} `bson:"dp"`
-		}
-		if err := cur.Decode(&row); err != nil {
-			return err
-		}
-
-		acc, ok := accs[row.FoundById]
+	credit := func(foundById int, contributors []Contributor, searchTime float64) {
+		acc, ok := accs[foundById]
 		if !ok {
 			acc = &finderAcc{}
-			accs[row.FoundById] = acc
+			accs[foundById] = acc
 		}
 
 		acc.count++
-		acc.totalTime += row.SearchTime
+		acc.totalTime += searchTime
 
 		// determine tier based on search time (creation to found)
-		tier := tierFromAge(row.SearchTime)
+		tier := tierFromAge(searchTime)
 		acc.tiers[tier]++
+
+		// posts processed before contributors existed credit the finder alone
+		if len(contributors) == 0 {
+			weighted[foundById]++
+		}
+		for _, c := range contributors {
+			weighted[c.UserId] += c.Weight
+		}
+	}
+
+	for cur.Next(ctx) {
+		var row struct {
+			FoundById    int           `bson:"found_by_id"`
+			Contributors []Contributor `bson:"contributors"`
+			FoundDate    time.Time     `bson:"found_date"`
+			SearchTime   float64       `bson:"search_time"`
+			Dp           struct {
+				Created time.Time `bson:"created"`
+			} `bson:"dp"`
+		}
+		if err := cur.Decode(&row); err != nil {
+			return err
+		}
+		credit(row.FoundById, row.Contributors, row.SearchTime)
 	}
 	if err := cur.Err(); err != nil {
 		return err
 	}
 
+	for _, p := range pending {
+		fp := p.post
+		if fp.IsFound && fp.FoundById > 0 && !fp.FoundDate.IsZero() {
+			credit(fp.FoundById, fp.Contributors, fp.FoundDate.Sub(p.created).Seconds())
+		}
+	}
+
 	for uid, acc := range accs {
 		u := getOrCreate(users, uid)
 		u.FoundTiersTotal = acc.count
@@ -234,6 +316,9 @@ func calcFinderStats(ctx context.Context, store *db.DB, users map[int]*FtpUser)
 			u.AvgSearchTime = acc.totalTime / float64(acc.count)
 		}
 	}
+	for uid, w := range weighted {
+		getOrCreate(users, uid).FoundWeighted = w
+	}
 	return nil
 }
 
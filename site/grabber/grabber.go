@@ -3,91 +3,330 @@ package grabber
 import (
 	"context"
 	"fmt"
+	"iter"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/findthisplace.eu/db"
 	"github.com/findthisplace.eu/dirty"
+	"github.com/findthisplace.eu/ratelimit"
+	"github.com/findthisplace.eu/settings"
 )
 
+const defaultConcurrency = 4
+
+// Result is what a run leaves behind for ftp processing. Posts, comments and
+// users are written to mongo page by page and not kept here, so memory stays
+// flat regardless of archive size; counters live on the run record.
 type Result struct {
-	Posts    []dirty.DirtyPost
-	Comments []dirty.DirtyComment
-	Users    map[int]*dirty.DirtyUser
+	Run *db.GrabberRun
+
+	// postIDs is only tracked for incremental runs, which touch a handful of
+	// pages; full runs read the ids back from the database instead.
+	postIDs []int
+}
+
+// PostIDs returns the posts that need ftp processing after the run. A full
+// run covers the whole archive, including pages saved before it was resumed.
+func (r *Result) PostIDs(ctx context.Context, store *db.DB) iter.Seq2[int, error] {
+	if r.Run.Mode == db.RunModeFull {
+		return store.DirtyPostIDs(ctx)
+	}
+	return db.IDs(r.postIDs)
 }
 
-func Run(ctx context.Context, fullRun *bool, store *db.DB) (*Result, error) {
+// Run fetches posts page by page and saves each page as soon as its comments
+// are in. Full runs also checkpoint every completed page, so a run that is
+// interrupted resumes after the last saved page instead of starting over.
+// Progress is recorded on run, which is saved after every page.
+func Run(ctx context.Context, run *db.GrabberRun, store *db.DB, sm *settings.Manager, limiter *ratelimit.Limiter) (*Result, error) {
 
 	log.Printf("Starting Dirty API fetcher...")
 
+	concurrency := loadConcurrency(ctx, sm)
+	fullRun := run.Mode == db.RunModeFull
+
 	var postsEndpoint string
-	if *fullRun {
+	if fullRun {
 		postsEndpoint = dirty.ApiPostsFullEndpoint
 	} else {
 		postsEndpoint = dirty.ApiPostsLatestEndpoint
 	}
 
 	postApi := dirty.New(postsEndpoint)
+	postApi.Limiter = limiter
 	const perPage = 42
 
-	log.Printf("Fetching page %d", 1)
-	first, err := postApi.GetPosts(ctx, 1, perPage)
+	cp := &db.Checkpoint{
+		Endpoint: postsEndpoint,
+		RunId:    run.Id,
+		Started:  run.Started,
+	}
+	if fullRun {
+		saved, err := store.LoadCheckpoint(ctx, postsEndpoint)
+		if err != nil {
+			log.Printf("Could not load checkpoint, starting from page 1: %v", err)
+		} else if saved != nil && saved.RunId == run.Id {
+			cp = saved
+			log.Printf("Resuming run %s after page %d/%d", cp.RunId, cp.Page, cp.PageCount)
+		}
+	}
+	startPage := cp.Page + 1
 
-	totalPages := first.PageCount
-	log.Printf("Total pages: %d", totalPages)
+	res := &Result{Run: run}
+	saveRun(ctx, store, run)
 
+	log.Printf("Fetching page %d", startPage)
+	first, err := postApi.GetPosts(ctx, startPage, perPage)
 	if err != nil {
 		return nil, err
 	}
 
-	res := &Result{
-		Posts:    make([]dirty.DirtyPost, 0, first.PostsTotal),
-		Comments: make([]dirty.DirtyComment, 0, 90000),
-		Users:    make(map[int]*dirty.DirtyUser, 9000),
-	}
+	totalPages := first.PageCount
+	log.Printf("Total pages: %d", totalPages)
+	cp.PageCount = totalPages
 
-	processBatch(ctx, first.Posts, res)
+	if err := savePage(ctx, store, first.Posts, res, concurrency, limiter); err != nil {
+		return nil, err
+	}
+	if fullRun {
+		saveCheckpoint(ctx, store, cp, startPage)
+	}
 
-	for page := 2; page <= totalPages; page++ {
+	// truncated is set when d3.ru runs out of posts before the last page,
+	// leaving the pages after it unfetched.
+	truncated := false
+	for page := startPage + 1; page <= totalPages; page++ {
 		log.Printf("Fetching page %d/%d", page, totalPages)
 
 		batch, err := postApi.GetPosts(ctx, page, perPage)
 		if err != nil {
-			return nil, err
+			// The client has already retried transient failures; losing one
+			// page is better than throwing away everything fetched so far.
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			log.Printf("Page %d failed, skipping: %v", page, err)
+			run.PageFailures++
+			continue
 		}
 		if len(batch.Posts) == 0 {
+			if page < totalPages {
+				log.Printf("Page %d/%d is empty, stopping", page, totalPages)
+				truncated = true
+			}
 			break
 		}
-		processBatch(ctx, batch.Posts, res)
+		if err := savePage(ctx, store, batch.Posts, res, concurrency, limiter); err != nil {
+			return nil, err
+		}
+		if fullRun {
+			saveCheckpoint(ctx, store, cp, page)
+		}
 	}
 
-	if err := store.Save(ctx, res.Posts, res.Comments, res.Users); err != nil {
-		return nil, fmt.Errorf("mongo save: %w", err)
+	if fullRun {
+		markVanishedPosts(ctx, store, run, cp.Started, truncated)
+		if err := store.ClearCheckpoint(ctx, postsEndpoint); err != nil {
+			log.Printf("Failed to clear checkpoint for run %s: %v", cp.RunId, err)
+		}
 	}
 
 	return res, nil
 }
 
-func processBatch(ctx context.Context, posts []dirty.DirtyPost, res *Result) {
+// markVanishedPosts flags the posts a full run did not come across. Every
+// page saved since started stamped its posts, including pages saved before
+// a resume, so anything older is gone from d3.ru. A run that lost pages or
+// stopped before the last one cannot tell missing posts from unfetched ones
+// and leaves them alone.
+func markVanishedPosts(ctx context.Context, store *db.DB, run *db.GrabberRun, started time.Time, truncated bool) {
+	if run.PageFailures > 0 || run.Posts == 0 || truncated {
+		log.Printf("Skipping vanished post detection for run %s: %d pages failed, stopped early: %v", run.Id, run.PageFailures, truncated)
+		return
+	}
+	n, err := store.MarkVanishedPosts(ctx, started)
+	if err != nil {
+		log.Printf("Failed to mark vanished posts: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("Marked %d posts as vanished", n)
+	}
+	run.PostsVanished += n
+	saveRun(ctx, store, run)
+}
+
+func saveRun(ctx context.Context, store *db.DB, run *db.GrabberRun) {
+	if err := store.SaveGrabberRun(ctx, run); err != nil {
+		log.Printf("Failed to save run %s: %v", run.Id, err)
+	}
+}
+
+// RunPosts re-fetches specific posts and their comments from d3.ru and saves
+// them, for targeted refreshes requested by an admin. Posts that can no longer
+// be fetched are skipped; the run fails only if none could be.
+func RunPosts(ctx context.Context, run *db.GrabberRun, store *db.DB, sm *settings.Manager, limiter *ratelimit.Limiter, postIDs []int) (*Result, error) {
+	log.Printf("Refreshing %d posts", len(postIDs))
+
+	postApi := dirty.New(dirty.ApiPostEndpoint)
+	postApi.Limiter = limiter
+
+	res := &Result{Run: run}
+	saveRun(ctx, store, run)
+
+	posts := make([]dirty.DirtyPost, 0, len(postIDs))
+	var lastErr error
+	for _, id := range postIDs {
+		p, err := postApi.GetPost(ctx, id)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			log.Printf("Post %d failed, skipping: %v", id, err)
+			lastErr = err
+			continue
+		}
+		posts = append(posts, *p)
+	}
+	if len(posts) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+
+	if err := savePage(ctx, store, posts, res, loadConcurrency(ctx, sm), limiter); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// page holds a single page of posts with their comments and users between
+// fetching and saving.
+type page struct {
+	Posts           []dirty.DirtyPost
+	Comments        []dirty.DirtyComment
+	Users           map[int]*dirty.DirtyUser
+	CommentFailures int
+
+	// Skipped lists posts that were returned but not saved because their
+	// comments could not be fetched.
+	Skipped []int
+}
+
+// savePage fetches comments for one page of posts, writes the page to mongo
+// and adds it to the run totals.
+func savePage(ctx context.Context, store *db.DB, posts []dirty.DirtyPost, res *Result, concurrency int, limiter *ratelimit.Limiter) error {
+	pg := &page{Users: make(map[int]*dirty.DirtyUser)}
+	processBatch(ctx, posts, pg, concurrency, limiter)
+
+	now := time.Now()
+	for i := range pg.Posts {
+		pg.Posts[i].SeenAt = &now
+	}
+
+	if err := store.Save(ctx, pg.Posts, pg.Comments, pg.Users); err != nil {
+		return fmt.Errorf("mongo save: %w", err)
+	}
+	if err := store.MarkPostsSeen(ctx, pg.Skipped, now); err != nil {
+		return fmt.Errorf("mongo save: %w", err)
+	}
+
+	// A successful fetch returns every comment a post still has, so any
+	// stored comment missing from it has been deleted.
+	kept := make(map[int][]int, len(pg.Posts))
+	for _, p := range pg.Posts {
+		kept[p.Id] = nil
+	}
+	for _, c := range pg.Comments {
+		kept[c.PostId] = append(kept[c.PostId], c.Id)
+	}
+	vanished, err := store.MarkVanishedComments(ctx, kept)
+	if err != nil {
+		return fmt.Errorf("mongo save: %w", err)
+	}
+
+	run := res.Run
+	run.CommentsVanished += vanished
+	run.Pages++
+	run.Posts += len(pg.Posts)
+	run.Comments += len(pg.Comments)
+	run.Users += len(pg.Users)
+	run.CommentFailures += pg.CommentFailures
+	saveRun(ctx, store, run)
+
+	if run.Mode != db.RunModeFull {
+		for _, p := range pg.Posts {
+			res.postIDs = append(res.postIDs, p.Id)
+		}
+	}
+	return nil
+}
+
+func saveCheckpoint(ctx context.Context, store *db.DB, cp *db.Checkpoint, page int) {
+	cp.Page = page
+	if err := store.SaveCheckpoint(ctx, cp); err != nil {
+		log.Printf("Failed to save checkpoint for run %s page %d: %v", cp.RunId, page, err)
+	}
+}
+
+// commentFetch is the outcome of fetching comments for a single post.
+type commentFetch struct {
+	comments []dirty.DirtyComment
+	err      error
+}
+
+// processBatch fetches comments for every post in the batch using a bounded
+// pool of workers. Workers only talk to the API; merging into res happens
+// afterwards on the calling goroutine in the original post order, so the
+// result is deterministic and res.Users is never written concurrently.
+func processBatch(ctx context.Context, posts []dirty.DirtyPost, res *page, concurrency int, limiter *ratelimit.Limiter) {
 	total := len(posts)
 	commentApi := dirty.New(dirty.ApiCommentsEndpoint)
+	commentApi.Limiter = limiter
 
-	for i, post := range posts {
-		log.Printf("Processing post %d/%d (%s)", i+1, total, post.Title)
+	fetched := make([]commentFetch, total)
+	jobs := make(chan int)
 
+	var wg sync.WaitGroup
+	for range min(max(concurrency, 1), total) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				log.Printf("Processing post %d/%d (%s)", i+1, total, posts[i].Title)
+				cr, err := commentApi.GetComments(ctx, posts[i])
+				if err != nil {
+					fetched[i].err = err
+					continue
+				}
+				fetched[i].comments = cr.Comments
+			}
+		}()
+	}
+
+	for i := range posts {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for i, post := range posts {
 		if post.User != nil {
 			res.Users[post.User.Id] = cloneUser(post.User)
 			post.UserId = post.User.Id
 			post.User = nil
 		}
 
-		cr, err := commentApi.GetComments(ctx, post)
-		if err != nil {
+		if err := fetched[i].err; err != nil {
 			log.Printf("Comments failed for post %d: %v", post.Id, err)
+			res.CommentFailures++
+			res.Skipped = append(res.Skipped, post.Id)
 			continue
 		}
 
-		for j := range cr.Comments {
-			c := &cr.Comments[j]
+		comments := fetched[i].comments
+		for j := range comments {
+			c := &comments[j]
 
 			if c.User != nil {
 				res.Users[c.User.Id] = cloneUser(c.User)
@@ -98,11 +337,22 @@ func processBatch(ctx context.Context, posts []dirty.DirtyPost, res *Result) {
 			c.PostId = post.Id
 		}
 
-		res.Comments = append(res.Comments, cr.Comments...)
+		res.Comments = append(res.Comments, comments...)
 		res.Posts = append(res.Posts, post)
 	}
 }
 
+// loadConcurrency reads the number of parallel comment fetches from settings,
+// falling back to defaultConcurrency while it is unset or below 1, which
+// would start no workers at all.
+func loadConcurrency(ctx context.Context, sm *settings.Manager) int {
+	n, err := sm.GetGrabberConcurrency(ctx)
+	if err != nil || n < 1 {
+		return defaultConcurrency
+	}
+	return n
+}
+
 func cloneUser(u *dirty.DirtyUser) *dirty.DirtyUser {
 	if u == nil {
 		return nil
@@ -0,0 +1,106 @@
+package grabber
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/findthisplace.eu/db"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	leaseName       = "grabber"
+	leaseTTL        = 2 * time.Minute
+	leaseRenewEvery = 30 * time.Second
+)
+
+// newOwnerId identifies this process as a lease holder: host and pid for
+// humans, plus a random suffix so a restarted process never mistakes an old
+// lease for its own.
+func newOwnerId() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s/%d/%s", host, os.Getpid(), primitive.NewObjectID().Hex())
+}
+
+// holdLease takes the run lease shared by all instances using the same
+// database and renews it in the background. The returned context is cancelled
+// if the lease is lost, so a run that has been taken over stops writing.
+// release must be called once the run is over.
+func (s *Scheduler) holdLease(ctx context.Context) (context.Context, func(), error) {
+	ok, err := s.store.AcquireLease(ctx, leaseName, s.owner, leaseTTL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("grabber: acquire lease: %w", err)
+	}
+	if !ok {
+		holder := "another instance"
+		if l, err := s.store.LoadLease(ctx, leaseName); err == nil && l != nil {
+			holder = l.Owner
+		}
+		return nil, nil, fmt.Errorf("%w (lease held by %s)", ErrRunInProgress, holder)
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(leaseRenewEvery)
+		defer ticker.Stop()
+
+		renewed := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case <-runCtx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			ok, err := s.store.RenewLease(runCtx, leaseName, s.owner, leaseTTL)
+			switch {
+			case err != nil && time.Since(renewed) < leaseTTL:
+				log.Printf("[grabber] lease renewal failed, will retry: %v", err)
+			case err != nil:
+				log.Printf("[grabber] lease expired while renewal kept failing (%v), stopping run", err)
+				cancel()
+				return
+			case !ok:
+				log.Println("[grabber] lease was taken over by another instance, stopping run")
+				cancel()
+				return
+			default:
+				renewed = time.Now()
+			}
+		}
+	}()
+
+	release := func() {
+		close(done)
+		cancel()
+
+		// The run context may already be cancelled on shutdown; release
+		// anyway so the next instance does not wait for the lease to expire.
+		releaseCtx, releaseCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer releaseCancel()
+		if err := s.store.ReleaseLease(releaseCtx, leaseName, s.owner); err != nil {
+			log.Printf("[grabber] failed to release lease: %v", err)
+		}
+	}
+	return runCtx, release, nil
+}
+
+// Owner is the id this instance uses when holding the run lease.
+func (s *Scheduler) Owner() string {
+	return s.owner
+}
+
+// Lease returns the current holder of the run lease, or nil if it is free.
+func (s *Scheduler) Lease(ctx context.Context) (*db.Lease, error) {
+	return s.store.LoadLease(ctx, leaseName)
+}
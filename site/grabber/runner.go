@@ -2,88 +2,337 @@ package grabber
 
 import (
 	"context"
+	"errors"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/findthisplace.eu/db"
+	"github.com/findthisplace.eu/dirty"
 	"github.com/findthisplace.eu/ftp"
+	"github.com/findthisplace.eu/ratelimit"
 	"github.com/findthisplace.eu/settings"
 )
 
-const checkInterval = 5 * time.Minute
-const runThreshold = 2 * time.Hour
+// Defaults for the scheduler settings, used while they are unset or invalid.
+const defaultCheckInterval = 5 * time.Minute
+const defaultRunThreshold = 2 * time.Hour
 
-const fullRunThreshold = 24 * time.Hour
+const defaultFullRunThreshold = 24 * time.Hour
+
+// ErrRunInProgress is returned by Trigger while another run holds the lock.
+var ErrRunInProgress = errors.New("grabber: a run is already in progress")
+
+// Scheduler runs the grabber periodically and on demand. Scheduled and manual
+// runs share one lock, so they never overlap, and a lease in mongo extends
+// that to every instance using the same database.
+type Scheduler struct {
+	store   *db.DB
+	sm      *settings.Manager
+	limiter *ratelimit.Limiter
+	owner   string
+
+	ctx     context.Context
+	running sync.Mutex
+}
+
+func NewScheduler(store *db.DB, sm *settings.Manager, limiter *ratelimit.Limiter) *Scheduler {
+	return &Scheduler{store: store, sm: sm, limiter: limiter, owner: newOwnerId()}
+}
+
+// Start launches the background scheduler. ctx also bounds runs started
+// through Trigger.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.ctx = ctx
 
-func StartBackground(ctx context.Context, store *db.DB, sm *settings.Manager) {
 	go func() {
 		log.Println("[grabber] background scheduler started")
 
-		runIfNeeded(ctx, store, sm)
-
-		ticker := time.NewTicker(checkInterval)
-		defer ticker.Stop()
+		s.runIfNeeded(ctx)
 
 		for {
+			// Re-read every tick so a changed interval applies without a restart.
 			select {
 			case <-ctx.Done():
 				log.Println("[grabber] background scheduler stopped")
 				return
-			case <-ticker.C:
-				runIfNeeded(ctx, store, sm)
+			case <-time.After(checkInterval(ctx, s.sm)):
+				s.runIfNeeded(ctx)
 			}
 		}
 	}()
 }
 
-func runIfNeeded(ctx context.Context, store *db.DB, sm *settings.Manager) {
-	if runThrottled(ctx, sm) {
+// Trigger starts a run in the background, bypassing the throttle, and returns
+// its record straight away so the caller can poll it by id. With postIDs only
+// those posts are covered: they are refreshed, or just reprocessed if mode is
+// reprocess.
+func (s *Scheduler) Trigger(mode string, postIDs []int) (*db.GrabberRun, error) {
+	if s.ctx == nil {
+		return nil, errors.New("grabber: scheduler not started")
+	}
+	if len(postIDs) > 0 && mode != db.RunModeReprocess {
+		mode = db.RunModePosts
+	}
+	if !s.running.TryLock() {
+		return nil, ErrRunInProgress
+	}
+
+	runCtx, release, err := s.holdLease(s.ctx)
+	if err != nil {
+		s.running.Unlock()
+		return nil, err
+	}
+
+	run := newRun(runCtx, s.store, mode)
+	run.PostIds = postIDs
+	if err := s.store.SaveGrabberRun(runCtx, run); err != nil {
+		release()
+		s.running.Unlock()
+		return nil, err
+	}
+
+	log.Printf("[grabber] %s run %s triggered manually", mode, run.Id)
+	go func() {
+		defer s.running.Unlock()
+		defer release()
+		s.execute(runCtx, run)
+	}()
+	return run, nil
+}
+
+// Reprocess re-runs ftp processing over the whole archive and returns once
+// done.
+func (s *Scheduler) Reprocess(ctx context.Context) (*db.GrabberRun, error) {
+	if !s.running.TryLock() {
+		return nil, ErrRunInProgress
+	}
+	defer s.running.Unlock()
+
+	runCtx, release, err := s.holdLease(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	run := db.NewGrabberRun(db.RunModeReprocess)
+	s.execute(runCtx, run)
+
+	if run.Status == db.RunStatusFail {
+		return run, errors.New(run.Error)
+	}
+	return run, nil
+}
+
+// RefreshPost re-grabs a single post with its comments and reprocesses it,
+// returning once done. Processing rewrites the statistics of every user, so
+// like any other run it takes the run lock and the lease, and fails with
+// ErrRunInProgress while another run holds them.
+func (s *Scheduler) RefreshPost(ctx context.Context, id int) (*db.GrabberRun, error) {
+	if !s.running.TryLock() {
+		return nil, ErrRunInProgress
+	}
+	defer s.running.Unlock()
+
+	runCtx, release, err := s.holdLease(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	run := db.NewGrabberRun(db.RunModePosts)
+	run.PostIds = []int{id}
+
+	log.Printf("[grabber] refreshing post %d", id)
+	s.execute(runCtx, run)
+
+	if run.Status == db.RunStatusFail {
+		return run, errors.New(run.Error)
+	}
+	return run, nil
+}
+
+func (s *Scheduler) runIfNeeded(ctx context.Context) {
+	if paused, _ := s.sm.GetGrabberPaused(ctx); paused {
+		log.Println("[grabber] scheduler is paused, skipping")
 		return
 	}
 
-	fullRun := fullBackfillDue(ctx, sm)
-	if fullRun {
-		log.Println("[grabber] starting full backfill run")
-	} else {
-		log.Println("[grabber] starting incremental run")
+	resuming := pendingCheckpoint(ctx, s.store)
+	if !resuming && runThrottled(ctx, s.sm) {
+		return
+	}
+
+	if !s.running.TryLock() {
+		log.Println("[grabber] another run is in progress, skipping")
+		return
 	}
+	defer s.running.Unlock()
 
-	result, err := Run(ctx, &fullRun, store)
+	runCtx, release, err := s.holdLease(ctx)
 	if err != nil {
-		log.Printf("[grabber] run failed: %v", err)
-		setStatus(ctx, sm, "fail")
+		log.Printf("[grabber] skipping run: %v", err)
 		return
 	}
+	defer release()
 
-	postIDs := make([]int, len(result.Posts))
-	for i, p := range result.Posts {
-		postIDs[i] = p.Id
+	mode := db.RunModeIncremental
+	if resuming || fullBackfillDue(runCtx, s.sm) {
+		mode = db.RunModeFull
 	}
+	s.execute(runCtx, newRun(runCtx, s.store, mode))
+}
+
+// execute performs the run described by run and records its outcome. The
+// caller must hold s.running.
+func (s *Scheduler) execute(ctx context.Context, run *db.GrabberRun) {
+	ConfigureLimiter(ctx, s.sm, s.limiter)
 
-	log.Println("[grabber] starting ftp processing")
-	if err := ftp.Process(ctx, store, postIDs); err != nil {
+	switch run.Mode {
+	case db.RunModeFull:
+		log.Println("[grabber] starting full backfill run")
+	case db.RunModePosts:
+		log.Printf("[grabber] starting refresh of %d posts", len(run.PostIds))
+	case db.RunModeReprocess:
+		if len(run.PostIds) > 0 {
+			log.Printf("[grabber] starting reprocessing of %d posts", len(run.PostIds))
+		} else {
+			log.Println("[grabber] starting reprocessing of the archive")
+		}
+	default:
+		log.Println("[grabber] starting incremental run")
+	}
+
+	var summary *ftp.Summary
+	var err error
+	if run.Mode == db.RunModeReprocess {
+		// Nothing is fetched; the stored posts and comments are processed again.
+		ids := s.store.DirtyPostIDs(ctx)
+		if len(run.PostIds) > 0 {
+			ids = db.IDs(run.PostIds)
+		}
+		summary, err = ftp.Process(ctx, s.store, s.sm, ids, ftp.Options{Reextract: true})
+	} else {
+		var result *Result
+		if run.Mode == db.RunModePosts {
+			result, err = RunPosts(ctx, run, s.store, s.sm, s.limiter, run.PostIds)
+		} else {
+			result, err = Run(ctx, run, s.store, s.sm, s.limiter)
+		}
+		if err != nil {
+			log.Printf("[grabber] run failed: %v", err)
+			finishRun(ctx, s.store, s.sm, run, err)
+			return
+		}
+
+		log.Println("[grabber] starting ftp processing")
+		summary, err = ftp.Process(ctx, s.store, s.sm, result.PostIDs(ctx, s.store), ftp.Options{})
+	}
+	if err != nil {
 		log.Printf("[grabber] ftp processing failed: %v", err)
-		setStatus(ctx, sm, "fail")
+		finishRun(ctx, s.store, s.sm, run, err)
 		return
 	}
+	run.PostsFound = summary.NewlyFound
+	run.PostsUnfound = summary.Unfound
+	run.PostsMoved = summary.Moved
+	run.FindersChanged = summary.FinderChanged
+	run.PostsManual = summary.ManualSkipped
+	run.CommentsExtracted = summary.CommentsExtracted
+	run.CommentsMoved = summary.CommentsMoved
 
 	log.Println("[grabber] run completed successfully")
-	setStatus(ctx, sm, "success")
+	finishRun(ctx, s.store, s.sm, run, nil)
 
-	if fullRun {
-		if err := sm.SetLastFullGrabberTime(ctx, time.Now()); err != nil {
+	if run.Mode == db.RunModeFull {
+		if err := s.sm.SetLastFullGrabberTime(ctx, time.Now()); err != nil {
 			log.Printf("[grabber] failed to set last_full_grabber_time: %v", err)
 		}
 	}
 }
 
+// newRun creates the record for a run. A full run picks up the record of an
+// interrupted backfill if there is a checkpoint for one, so the totals cover
+// the whole backfill.
+func newRun(ctx context.Context, store *db.DB, mode string) *db.GrabberRun {
+	run := db.NewGrabberRun(mode)
+	if mode != db.RunModeFull {
+		return run
+	}
+
+	cp, err := store.LoadCheckpoint(ctx, dirty.ApiPostsFullEndpoint)
+	if err != nil || cp == nil {
+		return run
+	}
+
+	prev, err := store.LoadGrabberRun(ctx, cp.RunId)
+	if err != nil {
+		log.Printf("[grabber] could not load run %s: %v", cp.RunId, err)
+	}
+	if prev != nil {
+		*run = *prev
+	} else {
+		run.Id = cp.RunId
+	}
+	run.Status = db.RunStatusRunning
+	run.Finished = nil
+	run.Error = ""
+	run.Resumed = true
+	return run
+}
+
+// finishRun closes the run record and, for scheduled kinds of run, mirrors
+// the outcome into the last_grabber_* settings.
+func finishRun(ctx context.Context, store *db.DB, sm *settings.Manager, run *db.GrabberRun, runErr error) {
+	now := time.Now()
+	run.Finished = &now
+	run.Status = db.RunStatusSuccess
+	if runErr != nil {
+		run.Status = db.RunStatusFail
+		run.Error = runErr.Error()
+	}
+	if err := store.SaveGrabberRun(ctx, run); err != nil {
+		log.Printf("[grabber] failed to save run %s: %v", run.Id, err)
+	}
+	if run.Mode == db.RunModeFull || run.Mode == db.RunModeIncremental {
+		setStatus(ctx, sm, run.Status)
+	}
+}
+
+// pendingCheckpoint reports whether an interrupted full backfill is waiting to
+// be resumed. Such a run skips the throttle so a restart picks it up at once.
+func pendingCheckpoint(ctx context.Context, store *db.DB) bool {
+	cp, err := store.LoadCheckpoint(ctx, dirty.ApiPostsFullEndpoint)
+	if err != nil {
+		log.Printf("[grabber] could not read checkpoint: %v", err)
+		return false
+	}
+	if cp == nil {
+		return false
+	}
+	log.Printf("[grabber] found checkpoint for run %s at page %d/%d", cp.RunId, cp.Page, cp.PageCount)
+	return true
+}
+
+func checkInterval(ctx context.Context, sm *settings.Manager) time.Duration {
+	d, err := sm.GetGrabberCheckInterval(ctx)
+	if err != nil {
+		return defaultCheckInterval
+	}
+	return d
+}
+
 func fullBackfillDue(ctx context.Context, sm *settings.Manager) bool {
 	lastFull, err := sm.GetLastFullGrabberTime(ctx)
 	if err != nil {
 		log.Printf("[grabber] last_full_grabber_time unavailable (%v), full backfill due", err)
 		return true
 	}
-	if d := time.Since(lastFull); d >= fullRunThreshold {
+	threshold, err := sm.GetGrabberFullRunThreshold(ctx)
+	if err != nil {
+		threshold = defaultFullRunThreshold
+	}
+	if d := time.Since(lastFull); d >= threshold {
 		log.Printf("[grabber] last full backfill was %s ago, due", d.Round(time.Second))
 		return true
 	}
@@ -96,13 +345,33 @@ func runThrottled(ctx context.Context, sm *settings.Manager) bool {
 		log.Printf("[grabber] could not read last_grabber_time: %v, will run", err)
 		return false
 	}
-	if d := time.Since(lastTime); d < runThreshold {
+	threshold, err := sm.GetGrabberRunThreshold(ctx)
+	if err != nil {
+		threshold = defaultRunThreshold
+	}
+	if d := time.Since(lastTime); d < threshold {
 		log.Printf("[grabber] last run was %s ago, skipping", d.Round(time.Second))
 		return true
 	}
 	return false
 }
 
+// ConfigureLimiter applies the rate limit settings, keeping the defaults for
+// any that are unset. It runs at startup, whenever one of the settings is
+// saved and before every run, which picks up changes made on another
+// instance.
+func ConfigureLimiter(ctx context.Context, sm *settings.Manager, limiter *ratelimit.Limiter) {
+	rate, err := sm.GetRateLimitPerSecond(ctx)
+	if err != nil {
+		rate = ratelimit.DefaultRate
+	}
+	burst, err := sm.GetRateLimitBurst(ctx)
+	if err != nil {
+		burst = ratelimit.DefaultBurst
+	}
+	limiter.Configure(rate, burst)
+}
+
 func setStatus(ctx context.Context, sm *settings.Manager, status string) {
 	if err := sm.SetLastGrabberTime(ctx, time.Now()); err != nil {
 		log.Printf("[grabber] failed to set last_grabber_time: %v", err)
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"slices"
@@ -49,7 +50,7 @@ func (api *API) handleAuthSession(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Verify session with d3.ru
-	valid := verifyD3Session(req.UID, req.SID)
+	valid := api.verifyD3Session(r.Context(), req.UID, req.SID)
 	if !valid {
 		setJsonHeader(w)
 		json.NewEncoder(w).Encode(authResponse{Valid: false})
@@ -112,7 +113,7 @@ func (api *API) handleAuthMe(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Verify session is still valid with d3.ru
-	valid := verifyD3Session(uid, sid)
+	valid := api.verifyD3Session(r.Context(), uid, sid)
 	if !valid {
 		// Clear invalid cookies
 		clearAuthCookies(w, r)
@@ -157,7 +158,7 @@ func (api *API) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
 		return false
 	}
 
-	if !verifyD3Session(uid, sid) {
+	if !api.verifyD3Session(r.Context(), uid, sid) {
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return false
 	}
@@ -199,14 +200,18 @@ func clearAuthCookies(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func verifyD3Session(uid, sid string) bool {
+func (api *API) verifyD3Session(ctx context.Context, uid, sid string) bool {
 	client := &http.Client{Timeout: 5 * time.Second}
 
-	req, err := http.NewRequest("GET", "https://d3.ru/api/posts/subscriptions/", nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://d3.ru/api/posts/subscriptions/", nil)
 	if err != nil {
 		return false
 	}
 
+	if err := api.limiter.Wait(ctx, req.URL.Host); err != nil {
+		return false
+	}
+
 	req.Header.Set("X-Futuware-UID", uid)
 	req.Header.Set("X-Futuware-SID", sid)
 
@@ -5,15 +5,19 @@ import (
 
 	"github.com/findthisplace.eu/config"
 	"github.com/findthisplace.eu/db"
+	"github.com/findthisplace.eu/grabber"
+	"github.com/findthisplace.eu/ratelimit"
 	"github.com/findthisplace.eu/settings"
 )
 
-func NewAPIHandler(cfg *config.Config, sm *settings.Manager, store *db.DB) *API {
+func NewAPIHandler(cfg *config.Config, sm *settings.Manager, store *db.DB, limiter *ratelimit.Limiter, sched *grabber.Scheduler) *API {
 
 	return &API{
 		cfg:      cfg,
 		settings: sm,
 		store:    store,
+		limiter:  limiter,
+		grabber:  sched,
 	}
 }
 
@@ -34,5 +38,6 @@ func (api *API) RegisterEndpoints(mux *http.ServeMux, cfg *config.Config) {
 	api.RegisterTagsApi()
 	api.RegisterPostsApi()
 	api.RegisterWebhookApi()
+	api.RegisterGrabberApi()
 
 }
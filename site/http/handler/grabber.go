@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/findthisplace.eu/db"
+	"github.com/findthisplace.eu/ftp"
+	"github.com/findthisplace.eu/grabber"
+)
+
+const defaultRunsLimit = 50
+
+func (api *API) RegisterGrabberApi() {
+	api.mux.HandleFunc("GET /api/admin/grabber/runs", api.handleGrabberRuns)
+	api.mux.HandleFunc("GET /api/admin/grabber/runs/{id}", api.handleGrabberRun)
+	api.mux.HandleFunc("POST /api/admin/grabber/run", api.handleGrabberTrigger)
+	api.mux.HandleFunc("GET /api/admin/grabber/lock", api.handleGrabberLock)
+	api.mux.HandleFunc("POST /api/admin/grabber/dry-run", api.handleGrabberDryRun)
+}
+
+type grabberLockResponse struct {
+	Held     bool   `json:"held"`
+	Owner    string `json:"owner,omitempty"`
+	Self     bool   `json:"self"`
+	Instance string `json:"instance"`
+	Acquired string `json:"acquired,omitempty"`
+	Renewed  string `json:"renewed,omitempty"`
+	Expires  string `json:"expires,omitempty"`
+}
+
+type grabberTriggerRequest struct {
+	Mode    string `json:"mode"`
+	PostIds []int  `json:"post_ids,omitempty"`
+}
+
+type grabberDryRunRequest struct {
+	// PostIds limits the dry run to these posts; empty means all of them.
+	PostIds   []int `json:"post_ids,omitempty"`
+	Reextract bool  `json:"reextract"`
+}
+
+func (api *API) handleGrabberRuns(w http.ResponseWriter, r *http.Request) {
+	if !api.requireAdmin(w, r) {
+		return
+	}
+
+	limit := defaultRunsLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	runs, err := api.store.ListGrabberRuns(r.Context(), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	setJsonHeader(w)
+	json.NewEncoder(w).Encode(runs)
+}
+
+func (api *API) handleGrabberRun(w http.ResponseWriter, r *http.Request) {
+	if !api.requireAdmin(w, r) {
+		return
+	}
+
+	run, err := api.store.LoadGrabberRun(r.Context(), r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if run == nil {
+		http.Error(w, "run not found", http.StatusNotFound)
+		return
+	}
+
+	setJsonHeader(w)
+	json.NewEncoder(w).Encode(run)
+}
+
+func (api *API) handleGrabberTrigger(w http.ResponseWriter, r *http.Request) {
+	if !api.requireAdmin(w, r) {
+		return
+	}
+
+	var req grabberTriggerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	switch req.Mode {
+	case "":
+		req.Mode = db.RunModeIncremental
+	case db.RunModeIncremental, db.RunModeFull, db.RunModeReprocess:
+	default:
+		http.Error(w, "mode must be incremental, full or reprocess", http.StatusBadRequest)
+		return
+	}
+
+	run, err := api.grabber.Trigger(req.Mode, req.PostIds)
+	if errors.Is(err, grabber.ErrRunInProgress) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	setJsonHeader(w)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"run_id": run.Id,
+		"mode":   run.Mode,
+	})
+}
+
+func (api *API) handleGrabberLock(w http.ResponseWriter, r *http.Request) {
+	if !api.requireAdmin(w, r) {
+		return
+	}
+
+	lease, err := api.grabber.Lease(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := grabberLockResponse{Instance: api.grabber.Owner()}
+	if lease != nil {
+		resp.Held = lease.Expires.After(time.Now())
+		resp.Owner = lease.Owner
+		resp.Self = lease.Owner == api.grabber.Owner()
+		resp.Acquired = lease.Acquired.UTC().Format(time.RFC3339)
+		resp.Renewed = lease.Renewed.UTC().Format(time.RFC3339)
+		resp.Expires = lease.Expires.UTC().Format(time.RFC3339)
+	}
+
+	setJsonHeader(w)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleGrabberDryRun runs ftp processing without writing anything and
+// returns what it would have changed. It runs in the request, so a dry run
+// over the whole archive takes as long as reprocessing it.
+func (api *API) handleGrabberDryRun(w http.ResponseWriter, r *http.Request) {
+	if !api.requireAdmin(w, r) {
+		return
+	}
+
+	var req grabberDryRunRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ids := api.store.DirtyPostIDs(r.Context())
+	if len(req.PostIds) > 0 {
+		ids = db.IDs(req.PostIds)
+	}
+
+	summary, err := ftp.Process(r.Context(), api.store, api.settings, ids,
+		ftp.Options{Reextract: req.Reextract, DryRun: true})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	setJsonHeader(w)
+	json.NewEncoder(w).Encode(summary)
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/findthisplace.eu/db"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -43,6 +44,7 @@ func (api *API) handleMapPosts(w http.ResponseWriter, r *http.Request) {
 			"path":                       "$post",
 			"preserveNullAndEmptyArrays": false,
 		}},
+		bson.M{"$match": bson.M{"post.vanished": db.NotVanished}},
 		bson.M{"$lookup": bson.M{
 			"from":         "dirty_users",
 			"localField":   "post.user_id",
@@ -2,12 +2,20 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/findthisplace.eu/db"
+	"github.com/findthisplace.eu/dirty"
+	"github.com/findthisplace.eu/ftp"
+	"github.com/findthisplace.eu/grabber"
+	"github.com/findthisplace.eu/settings"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 var tierBounds = []float64{
@@ -18,27 +26,35 @@ var tierBounds = []float64{
 }
 
 type notFoundPostResponse struct {
-	Id           int     `json:"id"`
-	Title        string  `json:"title"`
-	MainImageURL string  `json:"main_image_url"`
-	UserID       int     `json:"user_id,omitempty"`
-	Username     string  `json:"username"`
-	Gender       string  `json:"gender"`
-	CreatedDate  string  `json:"created_date"`
-	IsFound      bool    `json:"is_found"`
-	Tier         int     `json:"tier"`
-	Latitude     float64 `json:"latitude,omitempty"`
-	Longitude    float64 `json:"longitude,omitempty"`
-	FoundByID    int     `json:"found_by_id,omitempty"`
-	FoundBy      string  `json:"found_by,omitempty"`
-	FoundDate    string  `json:"found_date,omitempty"`
+	Id              int               `json:"id"`
+	Title           string            `json:"title"`
+	MainImageURL    string            `json:"main_image_url"`
+	UserID          int               `json:"user_id,omitempty"`
+	Username        string            `json:"username"`
+	Gender          string            `json:"gender"`
+	CreatedDate     string            `json:"created_date"`
+	IsFound         bool              `json:"is_found"`
+	Tier            int               `json:"tier"`
+	Latitude        float64           `json:"latitude,omitempty"`
+	Longitude       float64           `json:"longitude,omitempty"`
+	FoundByID       int               `json:"found_by_id,omitempty"`
+	FoundBy         string            `json:"found_by,omitempty"`
+	Contributors    []ftp.Contributor `json:"contributors,omitempty"`
+	FoundDate       string            `json:"found_date,omitempty"`
+	FoundDateSource string            `json:"found_date_source,omitempty"`
+	Confidence      float64           `json:"confidence,omitempty"`
+	Reasons         []string          `json:"reasons,omitempty"`
 }
 
 func (api *API) RegisterPostsApi() {
 	api.mux.HandleFunc("GET /api/posts/not-found", api.handleNotFoundPosts)
 	api.mux.HandleFunc("GET /api/posts/{id}", api.handleGetPost)
+	api.mux.HandleFunc("GET /api/posts/{id}/history", api.handlePostHistory)
 	api.mux.HandleFunc("GET /api/admin/problematic-posts", api.handleProblematicPosts)
 	api.mux.HandleFunc("PATCH /api/admin/posts/{id}/edit", api.handleAdminPostEdit)
+	api.mux.HandleFunc("POST /api/admin/posts/{id}/refresh", api.handleAdminPostRefresh)
+	api.mux.HandleFunc("GET /api/admin/posts/{id}/candidates", api.handleAdminPostCandidates)
+	api.mux.HandleFunc("POST /api/admin/posts/{id}/found-rules/test", api.handleAdminTestFoundRules)
 }
 
 func (api *API) handleNotFoundPosts(w http.ResponseWriter, r *http.Request) {
@@ -67,6 +83,9 @@ func (api *API) handleNotFoundPosts(w http.ResponseWriter, r *http.Request) {
 			"path":                       "$post",
 			"preserveNullAndEmptyArrays": false,
 		}},
+		bson.M{"$match": bson.M{"post.vanished": db.NotVanished}},
+		// The tag sets searches apart from meta and other untagged posts,
+		// which are never found either.
 		bson.M{"$match": bson.M{"post.tags": "не найдено"}},
 	}
 
@@ -181,6 +200,7 @@ func (api *API) handleGetPost(w http.ResponseWriter, r *http.Request) {
 			"path":                       "$post",
 			"preserveNullAndEmptyArrays": false,
 		}},
+		bson.M{"$match": bson.M{"post.vanished": db.NotVanished}},
 		bson.M{"$lookup": bson.M{
 			"from":         "dirty_users",
 			"localField":   "post.user_id",
@@ -202,19 +222,21 @@ func (api *API) handleGetPost(w http.ResponseWriter, r *http.Request) {
 			"preserveNullAndEmptyArrays": true,
 		}},
 		bson.M{"$project": bson.M{
-			"_id":            1,
-			"title":          "$post.title",
-			"main_image_url": "$post.main_image_url",
-			"user_id":        "$post.user_id",
-			"username":       "$user.login",
-			"gender":         "$user.gender",
-			"created":        "$post.created",
-			"is_found":       1,
-			"latitude":       1,
-			"longitude":      1,
-			"found_by_id":    1,
-			"found_by":       "$foundby.login",
-			"found_date":     1,
+			"_id":               1,
+			"title":             "$post.title",
+			"main_image_url":    "$post.main_image_url",
+			"user_id":           "$post.user_id",
+			"username":          "$user.login",
+			"gender":            "$user.gender",
+			"created":           "$post.created",
+			"is_found":          1,
+			"latitude":          1,
+			"longitude":         1,
+			"found_by_id":       1,
+			"found_by":          "$foundby.login",
+			"found_date":        1,
+			"found_date_source": 1,
+			"contributors":      1,
 		}},
 	}
 
@@ -239,17 +261,19 @@ func (api *API) handleGetPost(w http.ResponseWriter, r *http.Request) {
 	doc := raw[0]
 	now := time.Now()
 	resp := notFoundPostResponse{
-		Id:           intFromBson(doc["_id"]),
-		Title:        strFromBson(doc["title"]),
-		MainImageURL: strFromBson(doc["main_image_url"]),
-		UserID:       intFromBson(doc["user_id"]),
-		Username:     strFromBson(doc["username"]),
-		Gender:       strFromBson(doc["gender"]),
-		Latitude:     floatFromBson(doc["latitude"]),
-		Longitude:    floatFromBson(doc["longitude"]),
-		FoundByID:    intFromBson(doc["found_by_id"]),
-		FoundBy:      strFromBson(doc["found_by"]),
-		FoundDate:    strFromBson(doc["found_date"]),
+		Id:              intFromBson(doc["_id"]),
+		Title:           strFromBson(doc["title"]),
+		MainImageURL:    strFromBson(doc["main_image_url"]),
+		UserID:          intFromBson(doc["user_id"]),
+		Username:        strFromBson(doc["username"]),
+		Gender:          strFromBson(doc["gender"]),
+		Latitude:        floatFromBson(doc["latitude"]),
+		Longitude:       floatFromBson(doc["longitude"]),
+		FoundByID:       intFromBson(doc["found_by_id"]),
+		FoundBy:         strFromBson(doc["found_by"]),
+		FoundDate:       strFromBson(doc["found_date"]),
+		FoundDateSource: strFromBson(doc["found_date_source"]),
+		Contributors:    contributorsFromBson(doc["contributors"]),
 	}
 
 	var foundDate time.Time
@@ -273,6 +297,39 @@ func (api *API) handleGetPost(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+type postHistoryResponse struct {
+	Id        int               `json:"id"`
+	Revisions []db.PostRevision `json:"revisions"`
+}
+
+func (api *API) handlePostHistory(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "invalid post id", http.StatusBadRequest)
+		return
+	}
+
+	n, err := api.store.DirtyPosts.CountDocuments(r.Context(), bson.M{"_id": id, "vanished": db.NotVanished})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n == 0 {
+		http.Error(w, "post not found", http.StatusNotFound)
+		return
+	}
+
+	revisions, err := api.store.ListPostRevisions(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	setJsonHeader(w)
+	json.NewEncoder(w).Encode(postHistoryResponse{Id: id, Revisions: revisions})
+}
+
 func (api *API) handleProblematicPosts(w http.ResponseWriter, r *http.Request) {
 	if !api.requireAdmin(w, r) {
 		return
@@ -280,21 +337,36 @@ func (api *API) handleProblematicPosts(w http.ResponseWriter, r *http.Request) {
 
 	hiddenTags, _ := api.settings.GetHiddenTags(r.Context())
 
-	pipeline := bson.A{
-		bson.M{"$match": bson.M{
+	problems := bson.A{
+		bson.M{
+			"is_found": true,
 			"$or": bson.A{
-				bson.M{
-					"is_found": true,
-					"$or": bson.A{
-						bson.M{"longitude": bson.M{"$exists": false}},
-						bson.M{"latitude": bson.M{"$exists": false}},
-						bson.M{"longitude": 0},
-						bson.M{"latitude": 0},
-					},
-				},
-				bson.M{"is_found": bson.M{"$ne": true}},
+				bson.M{"longitude": bson.M{"$exists": false}},
+				bson.M{"latitude": bson.M{"$exists": false}},
+				bson.M{"longitude": 0},
+				bson.M{"latitude": 0},
 			},
-		}},
+		},
+		bson.M{"is_found": bson.M{"$ne": true}},
+	}
+
+	// ?max_confidence=0.5 lists found posts scored below the threshold
+	// instead, the ones whose location or finder is most likely wrong.
+	if v := r.URL.Query().Get("max_confidence"); v != "" {
+		maxConfidence, err := strconv.ParseFloat(v, 64)
+		if err != nil || maxConfidence < 0 || maxConfidence > 1 {
+			http.Error(w, "max_confidence must be between 0 and 1", http.StatusBadRequest)
+			return
+		}
+		problems = bson.A{bson.M{
+			"is_found":        true,
+			"manual_override": bson.M{"$ne": true},
+			"confidence":      bson.M{"$lt": maxConfidence},
+		}}
+	}
+
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{"$or": problems}},
 		bson.M{"$lookup": bson.M{
 			"from":         "dirty_posts",
 			"localField":   "_id",
@@ -305,6 +377,7 @@ func (api *API) handleProblematicPosts(w http.ResponseWriter, r *http.Request) {
 			"path":                       "$post",
 			"preserveNullAndEmptyArrays": false,
 		}},
+		bson.M{"$match": bson.M{"post.vanished": db.NotVanished}},
 		bson.M{"$match": bson.M{
 			"$or": bson.A{
 				bson.M{"is_found": true},
@@ -354,6 +427,8 @@ func (api *API) handleProblematicPosts(w http.ResponseWriter, r *http.Request) {
 			"found_by_id":    1,
 			"found_by":       "$foundby.login",
 			"found_date":     1,
+			"confidence":     1,
+			"reasons":        1,
 		}},
 		bson.M{"$sort": bson.M{"created": -1}},
 	)
@@ -394,6 +469,12 @@ func (api *API) handleProblematicPosts(w http.ResponseWriter, r *http.Request) {
 			IsFound:      boolFromBson(doc["is_found"]),
 			FoundByID:    intFromBson(doc["found_by_id"]),
 			FoundBy:      strFromBson(doc["found_by"]),
+			Confidence:   floatFromBson(doc["confidence"]),
+		}
+		if reasons, ok := doc["reasons"].(bson.A); ok {
+			for _, reason := range reasons {
+				resp.Reasons = append(resp.Reasons, strFromBson(reason))
+			}
 		}
 		if fd, ok := doc["found_date"].(primitive.DateTime); ok && !fd.Time().IsZero() {
 			resp.FoundDate = fd.Time().UTC().Format(time.RFC3339)
@@ -416,6 +497,62 @@ type adminPostEditRequest struct {
 	Longitude *float64 `json:"longitude,omitempty"`
 	FoundByID *int     `json:"found_by_id,omitempty"`
 	FoundDate *int64   `json:"found_date,omitempty"`
+	// Contributors replaces the credited users, the finder first. Weights
+	// are scaled to add up to 1.
+	Contributors []ftp.Contributor `json:"contributors,omitempty"`
+}
+
+// normalizeContributors checks an edited contributor list and returns it
+// with roles set by position and weights scaled to add up to 1.
+func normalizeContributors(list []ftp.Contributor) ([]ftp.Contributor, error) {
+	if len(list) == 0 {
+		return nil, errors.New("contributors cannot be empty")
+	}
+	seen := make(map[int]bool, len(list))
+	total := 0.0
+	for _, c := range list {
+		if c.UserId <= 0 {
+			return nil, errors.New("contributor user_id must be positive")
+		}
+		if seen[c.UserId] {
+			return nil, errors.New("contributors must be distinct users")
+		}
+		if c.Weight <= 0 {
+			return nil, errors.New("contributor weight must be positive")
+		}
+		seen[c.UserId] = true
+		total += c.Weight
+	}
+
+	out := make([]ftp.Contributor, len(list))
+	for i, c := range list {
+		role := ftp.ContributorHint
+		if i == 0 {
+			role = ftp.ContributorFinder
+		}
+		out[i] = ftp.Contributor{UserId: c.UserId, Role: role, Weight: c.Weight / total}
+	}
+	return out, nil
+}
+
+func contributorsFromBson(v interface{}) []ftp.Contributor {
+	arr, ok := v.(bson.A)
+	if !ok {
+		return nil
+	}
+	out := make([]ftp.Contributor, 0, len(arr))
+	for _, item := range arr {
+		m, ok := item.(bson.M)
+		if !ok {
+			continue
+		}
+		out = append(out, ftp.Contributor{
+			UserId: intFromBson(m["user_id"]),
+			Role:   strFromBson(m["role"]),
+			Weight: floatFromBson(m["weight"]),
+		})
+	}
+	return out
 }
 
 func (api *API) handleAdminPostEdit(w http.ResponseWriter, r *http.Request) {
@@ -445,11 +582,30 @@ func (api *API) handleAdminPostEdit(w http.ResponseWriter, r *http.Request) {
 		update["latitude"] = *req.Latitude
 		update["longitude"] = *req.Longitude
 	}
-	if req.FoundByID != nil {
+	if req.Contributors != nil {
+		contributors, err := normalizeContributors(req.Contributors)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.FoundByID != nil && *req.FoundByID != contributors[0].UserId {
+			http.Error(w, "found_by_id must be the first contributor", http.StatusBadRequest)
+			return
+		}
+		update["contributors"] = contributors
+		update["found_by_id"] = contributors[0].UserId
+	} else if req.FoundByID != nil {
+		// A new finder replaces whatever credit the old one shared.
 		update["found_by_id"] = *req.FoundByID
+		contributors := []ftp.Contributor{}
+		if *req.FoundByID > 0 {
+			contributors = append(contributors, ftp.Contributor{UserId: *req.FoundByID, Role: ftp.ContributorFinder, Weight: 1})
+		}
+		update["contributors"] = contributors
 	}
 	if req.FoundDate != nil {
 		update["found_date"] = primitive.DateTime(*req.FoundDate * 1000)
+		update["found_date_source"] = ftp.FoundDateManual
 	}
 
 	if len(update) == 0 {
@@ -458,6 +614,8 @@ func (api *API) handleAdminPostEdit(w http.ResponseWriter, r *http.Request) {
 	}
 
 	update["manual_override"] = true
+	update["confidence"] = 1.0
+	update["reasons"] = bson.A{ftp.ReasonManualOverride}
 
 	var current bson.M
 	if err := api.store.FtpPosts.FindOne(r.Context(), bson.M{"_id": id}).Decode(&current); err == nil {
@@ -499,3 +657,175 @@ func (api *API) handleAdminPostEdit(w http.ResponseWriter, r *http.Request) {
 		"updated": update,
 	})
 }
+
+func (api *API) handleAdminPostRefresh(w http.ResponseWriter, r *http.Request) {
+	if !api.requireAdmin(w, r) {
+		return
+	}
+
+	idStr := r.PathValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "invalid post id", http.StatusBadRequest)
+		return
+	}
+
+	run, err := api.grabber.RefreshPost(r.Context(), id)
+	if errors.Is(err, grabber.ErrRunInProgress) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	setJsonHeader(w)
+	json.NewEncoder(w).Encode(run)
+}
+
+type postCandidateResponse struct {
+	CommentId int       `json:"comment_id"`
+	UserId    int       `json:"user_id"`
+	Login     string    `json:"login"`
+	Provider  string    `json:"provider"`
+	URL       string    `json:"url"`
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	Precision int       `json:"precision"`
+	Rating    int       `json:"rating"`
+	Created   int64     `json:"created"`
+	View      *ftp.View `json:"view,omitempty"`
+}
+
+func (api *API) handleAdminPostCandidates(w http.ResponseWriter, r *http.Request) {
+	if !api.requireAdmin(w, r) {
+		return
+	}
+
+	idStr := r.PathValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "invalid post id", http.StatusBadRequest)
+		return
+	}
+
+	var fp ftp.FtpPost
+	err = api.store.FtpPosts.FindOne(r.Context(), bson.M{"_id": id}).Decode(&fp)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "post not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	userIds := make(bson.A, 0, len(fp.Candidates))
+	for _, c := range fp.Candidates {
+		userIds = append(userIds, c.UserId)
+	}
+	logins := make(map[int]string, len(userIds))
+	if len(userIds) > 0 {
+		cursor, err := api.store.DirtyUsers.Find(r.Context(), bson.M{"_id": bson.M{"$in": userIds}})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var users []bson.M
+		if err := cursor.All(r.Context(), &users); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, u := range users {
+			logins[intFromBson(u["_id"])] = strFromBson(u["login"])
+		}
+	}
+
+	results := make([]postCandidateResponse, 0, len(fp.Candidates))
+	for _, c := range fp.Candidates {
+		results = append(results, postCandidateResponse{
+			CommentId: c.CommentId,
+			UserId:    c.UserId,
+			Login:     logins[c.UserId],
+			Provider:  c.Provider,
+			URL:       c.URL,
+			Latitude:  c.Lat,
+			Longitude: c.Lng,
+			Precision: c.Precision,
+			Rating:    c.Rating,
+			Created:   c.Created.Unix(),
+			View:      c.View,
+		})
+	}
+
+	setJsonHeader(w)
+	json.NewEncoder(w).Encode(results)
+}
+
+type testFoundRulesResponse struct {
+	*ftp.RuleResult
+	PostId int `json:"post_id"`
+	// StoredIsFound is what the last processing decided.
+	StoredIsFound bool `json:"stored_is_found"`
+}
+
+// handleAdminTestFoundRules runs a found rule set against a post without
+// saving it. An empty body tries the current rules.
+func (api *API) handleAdminTestFoundRules(w http.ResponseWriter, r *http.Request) {
+	if !api.requireAdmin(w, r) {
+		return
+	}
+
+	idStr := r.PathValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "invalid post id", http.StatusBadRequest)
+		return
+	}
+
+	var body interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	rules := ftp.LoadFoundRules(r.Context(), api.settings)
+	if body != nil {
+		rules, err = settings.ParseFoundRules(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var dp dirty.DirtyPost
+	err = api.store.DirtyPosts.FindOne(r.Context(), bson.M{"_id": id}).Decode(&dp)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "post not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result, err := ftp.EvaluateFoundRules(rules, &dp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var stored ftp.PostState
+	err = api.store.FtpPosts.FindOne(r.Context(), bson.M{"_id": id}).Decode(&stored)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	setJsonHeader(w)
+	json.NewEncoder(w).Encode(testFoundRulesResponse{
+		RuleResult:    result,
+		PostId:        id,
+		StoredIsFound: stored.IsFound,
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/findthisplace.eu/grabber"
 	"github.com/findthisplace.eu/settings"
 )
 
@@ -49,10 +50,20 @@ func (api *API) handleUpdateSetting(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := settings.Set(r.Context(), api.settings, req.Name, req.Value); err != nil {
+	value, err := settings.Validate(req.Name, req.Value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := settings.Set(r.Context(), api.settings, req.Name, value); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	if req.Name == settings.RateLimitPerSecond || req.Name == settings.RateLimitBurst {
+		grabber.ConfigureLimiter(r.Context(), api.settings, api.limiter)
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/findthisplace.eu/db"
 	"go.mongodb.org/mongo-driver/bson"
 )
 
@@ -42,6 +43,7 @@ func (api *API) RegisterTagsApi() {
 
 func (api *API) handleTags(w http.ResponseWriter, r *http.Request) {
 	pipeline := bson.A{
+		bson.M{"$match": bson.M{"vanished": db.NotVanished}},
 		bson.M{"$lookup": bson.M{
 			"from":         "ftp_posts",
 			"localField":   "_id",
@@ -5,6 +5,8 @@ import (
 
 	"github.com/findthisplace.eu/config"
 	"github.com/findthisplace.eu/db"
+	"github.com/findthisplace.eu/grabber"
+	"github.com/findthisplace.eu/ratelimit"
 	"github.com/findthisplace.eu/settings"
 )
 
@@ -13,4 +15,6 @@ type API struct {
 	mux      *http.ServeMux
 	settings *settings.Manager
 	store    *db.DB
+	limiter  *ratelimit.Limiter
+	grabber  *grabber.Scheduler
 }
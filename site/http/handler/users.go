@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/findthisplace.eu/db"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -32,6 +33,7 @@ type searcherResponse struct {
 	FoundTier3      int     `json:"found_tier3"`
 	FoundTier4      int     `json:"found_tier4"`
 	AvgSearchTime   float64 `json:"avg_search_time"`
+	FoundWeighted   float64 `json:"found_weighted"`
 }
 
 type userPostResponse struct {
@@ -68,6 +70,7 @@ type userDetailResponse struct {
 	FoundTier3        int                `json:"found_tier3"`
 	FoundTier4        int                `json:"found_tier4"`
 	AvgSearchTime     float64            `json:"avg_search_time"`
+	FoundWeighted     float64            `json:"found_weighted"`
 	Posts             []userPostResponse `json:"posts"`
 }
 
@@ -101,6 +104,15 @@ func (api *API) handleSearchers(w http.ResponseWriter, r *http.Request) {
 		limit, _ = strconv.Atoi(v)
 	}
 
+	// ?credit=weighted splits each find between its contributors and ranks
+	// by the summed weights; by default only the primary finder counts.
+	mode := r.URL.Query().Get("credit")
+	if mode != "" && mode != "primary" && mode != "weighted" {
+		http.Error(w, "credit must be primary or weighted", http.StatusBadRequest)
+		return
+	}
+	weighted := mode == "weighted"
+
 	hiddenTags, _ := api.settings.GetHiddenTags(r.Context())
 
 	// Build pipeline to compute searcher stats dynamically, filtering hidden tags
@@ -116,6 +128,7 @@ func (api *API) handleSearchers(w http.ResponseWriter, r *http.Request) {
 			"path":                       "$post",
 			"preserveNullAndEmptyArrays": false,
 		}},
+		bson.M{"$match": bson.M{"post.vanished": db.NotVanished}},
 	}
 
 	if len(hiddenTags) > 0 {
@@ -145,20 +158,42 @@ func (api *API) handleSearchers(w http.ResponseWriter, r *http.Request) {
 				"default": 4,
 			}},
 		}},
+	)
+
+	// Each post credits a list of users. In weighted mode hint givers share
+	// found_weighted, but the tier counts and search time stay with the
+	// primary finder, as in ftp_users.
+	var credit interface{} = bson.A{bson.M{"user_id": "$found_by_id", "weight": 1}}
+	sortBy := "found_tiers_total"
+	if weighted {
+		credit = bson.M{"$ifNull": bson.A{"$contributors", credit}}
+		sortBy = "found_weighted"
+	}
+	primaryTier := func(tier int) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{
+			bson.M{"$and": bson.A{"$primary", bson.M{"$eq": bson.A{"$tier", tier}}}}, 1, 0,
+		}}}
+	}
+
+	pipeline = append(pipeline,
+		bson.M{"$addFields": bson.M{"credit": credit}},
+		bson.M{"$unwind": "$credit"},
+		bson.M{"$addFields": bson.M{"primary": bson.M{"$eq": bson.A{"$credit.user_id", "$found_by_id"}}}},
 		bson.M{"$group": bson.M{
-			"_id":             "$found_by_id",
-			"found_tier0":     bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$tier", 0}}, 1, 0}}},
-			"found_tier1":     bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$tier", 1}}, 1, 0}}},
-			"found_tier2":     bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$tier", 2}}, 1, 0}}},
-			"found_tier3":     bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$tier", 3}}, 1, 0}}},
-			"found_tier4":     bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$tier", 4}}, 1, 0}}},
-			"avg_search_time": bson.M{"$avg": "$search_time"},
+			"_id":             "$credit.user_id",
+			"found_weighted":  bson.M{"$sum": "$credit.weight"},
+			"found_tier0":     primaryTier(0),
+			"found_tier1":     primaryTier(1),
+			"found_tier2":     primaryTier(2),
+			"found_tier3":     primaryTier(3),
+			"found_tier4":     primaryTier(4),
+			"avg_search_time": bson.M{"$avg": bson.M{"$cond": bson.A{"$primary", "$search_time", nil}}},
 		}},
 		bson.M{"$addFields": bson.M{
 			"found_tiers_total": bson.M{"$add": bson.A{"$found_tier0", "$found_tier1", "$found_tier2", "$found_tier3", "$found_tier4"}},
 		}},
-		bson.M{"$match": bson.M{"found_tiers_total": bson.M{"$gt": 0}}},
-		bson.M{"$sort": bson.M{"found_tiers_total": -1}},
+		bson.M{"$match": bson.M{sortBy: bson.M{"$gt": 0}}},
+		bson.M{"$sort": bson.D{{Key: sortBy, Value: -1}, {Key: "_id", Value: 1}}},
 	)
 
 	if limit > 0 {
@@ -187,6 +222,7 @@ func (api *API) handleSearchers(w http.ResponseWriter, r *http.Request) {
 			"found_tier3":       1,
 			"found_tier4":       1,
 			"avg_search_time":   1,
+			"found_weighted":    1,
 		}},
 	)
 
@@ -216,6 +252,7 @@ func (api *API) handleSearchers(w http.ResponseWriter, r *http.Request) {
 			FoundTier3:      intFromBson(doc["found_tier3"]),
 			FoundTier4:      intFromBson(doc["found_tier4"]),
 			AvgSearchTime:   floatFromBson(doc["avg_search_time"]),
+			FoundWeighted:   floatFromBson(doc["found_weighted"]),
 		})
 	}
 
@@ -233,7 +270,7 @@ func (api *API) handleAuthors(w http.ResponseWriter, r *http.Request) {
 
 	// Build pipeline to compute author stats dynamically, filtering hidden tags
 	pipeline := bson.A{
-		bson.M{"$match": bson.M{"user_id": bson.M{"$exists": true, "$ne": 0}}},
+		bson.M{"$match": bson.M{"user_id": bson.M{"$exists": true, "$ne": 0}, "vanished": db.NotVanished}},
 	}
 
 	if len(hiddenTags) > 0 {
@@ -380,6 +417,7 @@ func (api *API) handleUserDetail(w http.ResponseWriter, r *http.Request) {
 			"found_tier3":        1,
 			"found_tier4":        1,
 			"avg_search_time":    1,
+			"found_weighted":     1,
 		}},
 	}
 
@@ -420,11 +458,12 @@ func (api *API) handleUserDetail(w http.ResponseWriter, r *http.Request) {
 		FoundTier3:        intFromBson(doc["found_tier3"]),
 		FoundTier4:        intFromBson(doc["found_tier4"]),
 		AvgSearchTime:     floatFromBson(doc["avg_search_time"]),
+		FoundWeighted:     floatFromBson(doc["found_weighted"]),
 	}
 
 	// Fetch posts created by this user
 	authoredPipeline := bson.A{
-		bson.M{"$match": bson.M{"user_id": id}},
+		bson.M{"$match": bson.M{"user_id": id, "vanished": db.NotVanished}},
 	}
 	if len(hiddenTagsBson) > 0 {
 		authoredPipeline = append(authoredPipeline, bson.M{"$match": bson.M{"tags": bson.M{"$nin": hiddenTagsBson}}})
@@ -505,6 +544,7 @@ func (api *API) handleUserDetail(w http.ResponseWriter, r *http.Request) {
 			"path":                       "$post",
 			"preserveNullAndEmptyArrays": false,
 		}},
+		bson.M{"$match": bson.M{"post.vanished": db.NotVanished}},
 	}
 	if len(hiddenTagsBson) > 0 {
 		foundPipeline = append(foundPipeline, bson.M{"$match": bson.M{"post.tags": bson.M{"$nin": hiddenTagsBson}}})
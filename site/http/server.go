@@ -9,19 +9,21 @@ import (
 
 	"github.com/findthisplace.eu/config"
 	"github.com/findthisplace.eu/db"
+	"github.com/findthisplace.eu/grabber"
 	"github.com/findthisplace.eu/http/handler"
+	"github.com/findthisplace.eu/ratelimit"
 	"github.com/findthisplace.eu/settings"
 )
 
 //go:embed ui/dist/*
 var uiDist embed.FS
 
-func StartServer(cfg *config.Config, sm *settings.Manager, store *db.DB) (*stdhttp.Server, error) {
+func StartServer(cfg *config.Config, sm *settings.Manager, store *db.DB, limiter *ratelimit.Limiter, sched *grabber.Scheduler) (*stdhttp.Server, error) {
 
 	mux := stdhttp.NewServeMux()
 
 	registerWebSocketEndpoints(mux)
-	registerAPIEndpoints(mux, cfg, sm, store)
+	registerAPIEndpoints(mux, cfg, sm, store, limiter, sched)
 
 	handler.RegisterSpa(mux, uiDist)
 
@@ -53,9 +55,9 @@ func StartServer(cfg *config.Config, sm *settings.Manager, store *db.DB) (*stdht
 func registerWebSocketEndpoints(mux *stdhttp.ServeMux) {
 }
 
-func registerAPIEndpoints(mux *stdhttp.ServeMux, cfg *config.Config, sm *settings.Manager, store *db.DB) {
+func registerAPIEndpoints(mux *stdhttp.ServeMux, cfg *config.Config, sm *settings.Manager, store *db.DB, limiter *ratelimit.Limiter, sched *grabber.Scheduler) {
 
-	api := handler.NewAPIHandler(cfg, sm, store)
+	api := handler.NewAPIHandler(cfg, sm, store, limiter, sched)
 	api.RegisterEndpoints(mux, cfg)
 
 }
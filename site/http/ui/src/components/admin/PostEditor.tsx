@@ -60,6 +60,19 @@ interface CommentsResponse {
   comments: Comment[];
 }
 
+interface PostCandidate {
+  comment_id: number;
+  user_id: number;
+  login: string;
+  provider: string;
+  url: string;
+  latitude: number;
+  longitude: number;
+  precision: number;
+  rating: number;
+  created: number;
+}
+
 const customIcon = new L.Icon({
   iconUrl: markerIcon,
   iconSize: [32, 32],
@@ -237,6 +250,14 @@ async function fetchComments(postId: number): Promise<Comment[]> {
   return data.comments || [];
 }
 
+async function fetchCandidates(postId: number): Promise<PostCandidate[]> {
+  const res = await fetch(`/api/admin/posts/${postId}/candidates`);
+  if (!res.ok) {
+    throw new Error("Не удалось загрузить найденные координаты");
+  }
+  return res.json();
+}
+
 function MapClickHandler({
   onMapClick,
 }: {
@@ -280,6 +301,7 @@ export default function PostEditor({
   const [commentsLoading, setCommentsLoading] = useState(false);
   const [commentsError, setCommentsError] = useState("");
   const [selectedComment, setSelectedComment] = useState<Comment | null>(null);
+  const [candidates, setCandidates] = useState<PostCandidate[]>([]);
 
   useEffect(() => {
     if (!initialPost.title && !initialPost.username) {
@@ -313,6 +335,11 @@ export default function PostEditor({
     setSelectedComment(null);
     setCommentsError("");
 
+    setCandidates([]);
+    fetchCandidates(initialPost.id)
+      .then(setCandidates)
+      .catch(() => {});
+
     // Fetch comments
     setCommentsLoading(true);
     fetchComments(initialPost.id)
@@ -390,6 +417,15 @@ export default function PostEditor({
     }
   };
 
+  const handleSelectCandidate = (candidate: PostCandidate) => {
+    setLatitude(candidate.latitude.toString());
+    setLongitude(candidate.longitude.toString());
+    const comment = comments.find((c) => c.id === candidate.comment_id);
+    if (comment) {
+      setSelectedComment(comment);
+    }
+  };
+
   const formatDate = (timestamp: number) => {
     return new Date(timestamp * 1000).toLocaleString("ru-RU", {
       day: "numeric",
@@ -462,6 +498,33 @@ export default function PostEditor({
             </Box>
           </Box>
 
+          {/* Coordinates found in comments */}
+          {candidates.length > 0 && (
+            <>
+              <Typography variant="subtitle2" sx={{ mb: 1 }}>
+                Найдено в комментариях
+              </Typography>
+              <Box sx={{ display: "flex", flexWrap: "wrap", gap: 1, mb: 2 }}>
+                {candidates.map((candidate) => (
+                  <Chip
+                    key={`${candidate.comment_id}-${candidate.latitude}-${candidate.longitude}`}
+                    label={`${candidate.latitude.toFixed(5)}, ${candidate.longitude.toFixed(5)} · ${candidate.provider} · ${candidate.login || candidate.user_id}`}
+                    size="small"
+                    variant={
+                      selectedComment?.id === candidate.comment_id &&
+                      parseFloat(latitude) === candidate.latitude &&
+                      parseFloat(longitude) === candidate.longitude
+                        ? "filled"
+                        : "outlined"
+                    }
+                    color="primary"
+                    onClick={() => handleSelectCandidate(candidate)}
+                  />
+                ))}
+              </Box>
+            </>
+          )}
+
           {/* Map URL parser */}
           <Typography variant="subtitle2" sx={{ mb: 1 }}>
             Вставьте ссылку на карту
@@ -22,21 +22,50 @@ import SaveIcon from "@mui/icons-material/Save";
 import CancelIcon from "@mui/icons-material/Cancel";
 import LockIcon from "@mui/icons-material/Lock";
 import PlayArrowIcon from "@mui/icons-material/PlayArrow";
-import { useSettings, useUpdateSetting, Setting } from "./useSettings";
+import PauseIcon from "@mui/icons-material/Pause";
+import ReplayIcon from "@mui/icons-material/Replay";
+import { useSettings, useUpdateSetting, useTriggerGrabber, Setting } from "./useSettings";
 
 const PROTECTED_ADMIN_ID = 25377;
 
-const GRABBER_SETTINGS = ["last_grabber_time", "last_grabber_status"];
+const GRABBER_SETTINGS = [
+  "last_grabber_time",
+  "last_grabber_status",
+  "grabber_paused",
+];
 
 const SETTING_LABELS: Record<string, string> = {
   hidden_not_found_posts: "Скрытые ненайденные посты",
   hidden_tags: "Скрытые теги",
   admin_ids: "ID администраторов",
+  grabber_concurrency: "Параллельных загрузок комментариев",
+  grabber_check_interval_minutes: "Интервал проверки граббера (мин)",
+  grabber_run_threshold_minutes: "Пауза между запусками (мин)",
+  grabber_full_run_threshold_hours: "Интервал полного обхода (ч)",
+  rate_limit_per_second: "Запросов в секунду к одному хосту",
+  rate_limit_burst: "Запас запросов (burst)",
+  confirm_phrases: "Фразы автора, подтверждающие находку",
+  found_rules: "Правила определения найденных постов (JSON)",
 };
 
+const NUMBER_SETTINGS = [
+  "grabber_concurrency",
+  "grabber_check_interval_minutes",
+  "grabber_run_threshold_minutes",
+  "grabber_full_run_threshold_hours",
+  "rate_limit_per_second",
+  "rate_limit_burst",
+];
+
+const JSON_SETTINGS = ["found_rules"];
+
 function formatValue(name: string, value: unknown): string {
   if (value === null || value === undefined) return "—";
 
+  if (JSON_SETTINGS.includes(name)) {
+    return JSON.stringify(value);
+  }
+
   if (name === "last_grabber_time" && typeof value === "string") {
     try {
       return new Date(value).toLocaleString("ru-RU");
@@ -53,6 +82,13 @@ function formatValue(name: string, value: unknown): string {
 }
 
 function parseValue(name: string, input: string): unknown {
+  if (JSON_SETTINGS.includes(name)) {
+    try {
+      return JSON.parse(input);
+    } catch {
+      return input; // The server rejects it with a readable error
+    }
+  }
   if (name === "hidden_not_found_posts" || name === "admin_ids") {
     if (!input.trim()) return [];
     return input
@@ -60,7 +96,11 @@ function parseValue(name: string, input: string): unknown {
       .map((s) => parseInt(s.trim(), 10))
       .filter((n) => !isNaN(n));
   }
-  if (name === "hidden_tags") {
+  if (NUMBER_SETTINGS.includes(name)) {
+    const n = parseFloat(input.trim().replace(",", "."));
+    return isNaN(n) ? null : n;
+  }
+  if (name === "hidden_tags" || name === "confirm_phrases") {
     if (!input.trim()) return [];
     return input
       .split(/[,\n]+/)
@@ -81,9 +121,11 @@ function SettingRow({ setting, onSave, isSaving }: SettingRowProps) {
   const [editValue, setEditValue] = useState("");
 
   const handleEdit = () => {
-    const formatted = Array.isArray(setting.value)
-      ? setting.value.join(", ")
-      : String(setting.value ?? "");
+    const formatted = JSON_SETTINGS.includes(setting.name)
+      ? JSON.stringify(setting.value, null, 2)
+      : Array.isArray(setting.value)
+        ? setting.value.join(", ")
+        : String(setting.value ?? "");
     setEditValue(formatted);
     setIsEditing(true);
   };
@@ -119,14 +161,16 @@ function SettingRow({ setting, onSave, isSaving }: SettingRowProps) {
             size="small"
             fullWidth
             autoFocus
-            multiline={setting.name === "hidden_not_found_posts" || setting.name === "hidden_tags"}
-            rows={setting.name === "hidden_not_found_posts" || setting.name === "hidden_tags" ? 4 : undefined}
+            multiline={setting.name === "hidden_not_found_posts" || setting.name === "hidden_tags" || setting.name === "confirm_phrases" || JSON_SETTINGS.includes(setting.name)}
+            rows={JSON_SETTINGS.includes(setting.name) ? 8 : setting.name === "hidden_not_found_posts" || setting.name === "hidden_tags" || setting.name === "confirm_phrases" ? 4 : undefined}
             placeholder={
               setting.name.includes("ids") || setting.name.includes("posts")
                 ? "Через запятую: 1, 2, 3"
                 : setting.name === "hidden_tags"
                   ? "Через запятую: технический, модераторское"
-                  : ""
+                  : setting.name === "confirm_phrases"
+                    ? "Через запятую: да!, верно, угадали"
+                    : ""
             }
             helperText={
               setting.name === "admin_ids"
@@ -215,14 +259,22 @@ function SettingRow({ setting, onSave, isSaving }: SettingRowProps) {
 interface GrabberStatusProps {
   settings: Setting[];
   onTrigger: () => void;
+  onReprocess: () => void;
   isTriggering: boolean;
+  onTogglePause: (paused: boolean) => void;
+  isSaving: boolean;
 }
 
 function GrabberStatus({
   settings,
   onTrigger,
+  onReprocess,
   isTriggering,
+  onTogglePause,
+  isSaving,
 }: GrabberStatusProps) {
+  const paused =
+    settings.find((s) => s.name === "grabber_paused")?.value === true;
   const lastTime = settings.find((s) => s.name === "last_grabber_time")?.value;
   const lastStatus = settings.find(
     (s) => s.name === "last_grabber_status",
@@ -257,6 +309,21 @@ function GrabberStatus({
           </Typography>
           <Typography>{lastStatus ? String(lastStatus) : "—"}</Typography>
         </Box>
+        <Box>
+          <Typography variant="caption" color="text.secondary">
+            Расписание
+          </Typography>
+          <Typography>{paused ? "приостановлено" : "активно"}</Typography>
+        </Box>
+        <Button
+          variant="outlined"
+          size="small"
+          startIcon={paused ? <PlayArrowIcon /> : <PauseIcon />}
+          onClick={() => onTogglePause(!paused)}
+          disabled={isSaving}
+        >
+          {paused ? "Возобновить расписание" : "Приостановить расписание"}
+        </Button>
         <Button
           variant="contained"
           size="small"
@@ -272,6 +339,19 @@ function GrabberStatus({
         >
           Запустить обновление
         </Button>
+        <Tooltip title="Заново извлечь координаты из комментариев и пересчитать все посты, кроме исправленных вручную">
+          <span>
+            <Button
+              variant="outlined"
+              size="small"
+              startIcon={<ReplayIcon />}
+              onClick={onReprocess}
+              disabled={isTriggering}
+            >
+              Пересчитать архив
+            </Button>
+          </span>
+        </Tooltip>
       </Box>
     </Box>
   );
@@ -280,23 +360,18 @@ function GrabberStatus({
 export default function SettingsManager() {
   const { data: settings, isLoading, error, refetch } = useSettings();
   const updateMutation = useUpdateSetting();
-  const [isTriggering, setIsTriggering] = useState(false);
+  const triggerMutation = useTriggerGrabber();
 
   const handleSave = (setting: Setting) => {
     updateMutation.mutate(setting);
   };
 
-  const handleTriggerGrabber = async () => {
-    setIsTriggering(true);
-    try {
-      await Promise.all([
-        updateMutation.mutateAsync({ name: "last_grabber_time", value: null }),
-        updateMutation.mutateAsync({ name: "last_grabber_status", value: "" }),
-      ]);
-      refetch();
-    } finally {
-      setIsTriggering(false);
-    }
+  const handleTriggerGrabber = () => {
+    triggerMutation.mutate("incremental", { onSettled: () => refetch() });
+  };
+
+  const handleReprocess = () => {
+    triggerMutation.mutate("reprocess", { onSettled: () => refetch() });
   };
 
   // Ensure all known editable settings are shown, even if they don't exist in DB yet
@@ -333,6 +408,12 @@ export default function SettingsManager() {
         </Alert>
       )}
 
+      {triggerMutation.isError && (
+        <Alert severity="error" sx={{ mb: 2 }}>
+          {(triggerMutation.error as Error).message}
+        </Alert>
+      )}
+
       {updateMutation.isSuccess && (
         <Alert severity="success" sx={{ mb: 2 }}>
           Настройка сохранена
@@ -343,7 +424,12 @@ export default function SettingsManager() {
         <GrabberStatus
           settings={settings}
           onTrigger={handleTriggerGrabber}
-          isTriggering={isTriggering}
+          onReprocess={handleReprocess}
+          isTriggering={triggerMutation.isPending}
+          onTogglePause={(paused) =>
+            handleSave({ name: "grabber_paused", value: paused })
+          }
+          isSaving={updateMutation.isPending}
         />
       )}
 
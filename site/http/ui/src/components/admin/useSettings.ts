@@ -48,3 +48,30 @@ export function useUpdateSetting() {
     },
   });
 }
+
+export interface GrabberRunStarted {
+  run_id: string;
+  mode: string;
+}
+
+async function triggerGrabber(mode: "incremental" | "full" | "reprocess"): Promise<GrabberRunStarted> {
+  const res = await fetch("/api/admin/grabber/run", {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ mode }),
+  });
+  if (res.status === 409) throw new Error("Обновление уже выполняется");
+  if (!res.ok) throw new Error(`Failed to trigger grabber: ${res.status}`);
+  return res.json();
+}
+
+export function useTriggerGrabber() {
+  const queryClient = useQueryClient();
+
+  return useMutation({
+    mutationFn: triggerGrabber,
+    onSuccess: () => {
+      queryClient.invalidateQueries({ queryKey: ["settings"] });
+    },
+  });
+}
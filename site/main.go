@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
@@ -12,8 +13,10 @@ import (
 
 	"github.com/findthisplace.eu/config"
 	"github.com/findthisplace.eu/db"
+	"github.com/findthisplace.eu/ftp"
 	"github.com/findthisplace.eu/grabber"
 	ftphttp "github.com/findthisplace.eu/http"
+	"github.com/findthisplace.eu/ratelimit"
 	"github.com/findthisplace.eu/settings"
 )
 
@@ -30,6 +33,17 @@ func main() {
 	flag.IntVar(&port, "port", 8080, "HTTP server port")
 	flag.Parse()
 
+	// The reprocess subcommand has flags of its own, given after its name.
+	reprocessCmd := flag.Arg(0) == "reprocess"
+	reprocessFlags := flag.NewFlagSet("reprocess", flag.ExitOnError)
+	dryRun := reprocessFlags.Bool("dry-run", false, "print what would change without writing")
+	if reprocessCmd {
+		reprocessFlags.Parse(flag.Args()[1:])
+		if reprocessFlags.NArg() > 0 {
+			log.Fatalf("reprocess: unexpected arguments %v", reprocessFlags.Args())
+		}
+	}
+
 	ctx := context.Background()
 
 	store, err := db.Connect(ctx, "findthisplace")
@@ -40,9 +54,20 @@ func main() {
 
 	sm := settings.NewManager(store.FtpSettings)
 
+	limiter := ratelimit.New(ratelimit.DefaultRate, ratelimit.DefaultBurst)
+	grabber.ConfigureLimiter(ctx, sm, limiter)
+	ftp.SetRateLimiter(limiter)
+
+	sched := grabber.NewScheduler(store, sm, limiter)
+
+	if reprocessCmd {
+		reprocess(ctx, store, sm, sched, *dryRun)
+		return
+	}
+
 	grabberCtx, grabberCancel := context.WithCancel(ctx)
 	defer grabberCancel()
-	grabber.StartBackground(grabberCtx, store, sm)
+	sched.Start(grabberCtx)
 
 	commitShort := Commit
 	if len(commitShort) > 7 {
@@ -54,7 +79,7 @@ func main() {
 		Version: fmt.Sprintf("%s.%s", Version, commitShort),
 	}
 
-	srv, err := ftphttp.StartServer(cfg, sm, store)
+	srv, err := ftphttp.StartServer(cfg, sm, store, limiter, sched)
 	if err != nil {
 		log.Fatalf("failed to start server: %v", err)
 	}
@@ -78,3 +103,36 @@ func main() {
 
 	fmt.Println("server stopped")
 }
+
+// reprocess re-runs ftp processing over the whole archive without starting
+// the server, then prints what changed. With dryRun nothing is written and
+// the full diff is printed as JSON.
+func reprocess(ctx context.Context, store *db.DB, sm *settings.Manager, sched *grabber.Scheduler, dryRun bool) {
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if dryRun {
+		summary, err := ftp.Process(ctx, store, sm, store.DirtyPostIDs(ctx),
+			ftp.Options{Reextract: true, DryRun: true})
+		if err != nil {
+			log.Fatalf("dry run failed: %v", err)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(summary)
+		return
+	}
+
+	run, err := sched.Reprocess(ctx)
+	if err != nil {
+		log.Fatalf("reprocessing failed: %v", err)
+	}
+
+	fmt.Printf("reprocessed run %s\n", run.Id)
+	fmt.Printf("  comments extracted: %d (%d moved)\n", run.CommentsExtracted, run.CommentsMoved)
+	fmt.Printf("  posts newly found:  %d\n", run.PostsFound)
+	fmt.Printf("  posts unfound:      %d\n", run.PostsUnfound)
+	fmt.Printf("  posts moved:        %d\n", run.PostsMoved)
+	fmt.Printf("  finders changed:    %d\n", run.FindersChanged)
+	fmt.Printf("  manual, left alone: %d\n", run.PostsManual)
+}
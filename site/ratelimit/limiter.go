@@ -0,0 +1,113 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRate  = 2.0
+	DefaultBurst = 4
+)
+
+// Limiter keeps one token bucket per upstream host so that d3.ru and the map
+// providers are throttled independently of each other. All d3.ru subdomains
+// share a single bucket.
+type Limiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   int
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func New(rate float64, burst int) *Limiter {
+	l := &Limiter{buckets: make(map[string]*bucket)}
+	l.Configure(rate, burst)
+	return l
+}
+
+// Configure changes the refill rate (tokens per second) and bucket size for
+// every host. Non-positive values fall back to the defaults.
+func (l *Limiter) Configure(rate float64, burst int) {
+	if rate <= 0 {
+		rate = DefaultRate
+	}
+	if burst < 1 {
+		burst = DefaultBurst
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = rate
+	l.burst = burst
+	for _, b := range l.buckets {
+		b.tokens = min(b.tokens, float64(burst))
+	}
+}
+
+// Wait blocks until a request to host is allowed or ctx is done.
+// A nil Limiter never blocks.
+func (l *Limiter) Wait(ctx context.Context, host string) error {
+	if l == nil {
+		return nil
+	}
+	for {
+		delay := l.reserve(host)
+		if delay == 0 {
+			return nil
+		}
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// reserve takes a token for host if one is available and returns zero,
+// otherwise it returns how long until the next token is due.
+func (l *Limiter) reserve(host string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	key := bucketKey(host)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.burst), last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*l.rate, float64(l.burst))
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// bucketKey maps host to its bucket: the port is dropped and every d3.ru
+// subdomain, such as findthisplace.d3.ru, counts as d3.ru itself.
+func bucketKey(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	if strings.HasSuffix(host, ".d3.ru") {
+		return "d3.ru"
+	}
+	return host
+}
@@ -2,7 +2,10 @@ package settings
 
 import (
 	"context"
+	"fmt"
 	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 // GET
@@ -15,6 +18,37 @@ func (m *Manager) GetLastFullGrabberTime(ctx context.Context) (time.Time, error)
 func (m *Manager) GetLastGrabberStatus(ctx context.Context) (string, error) {
 	return Get[string](ctx, m, LastGrabberStatus)
 }
+func (m *Manager) GetGrabberConcurrency(ctx context.Context) (int, error) {
+	return getInt(ctx, m, GrabberConcurrency)
+}
+func (m *Manager) GetGrabberPaused(ctx context.Context) (bool, error) {
+	return Get[bool](ctx, m, GrabberPaused)
+}
+func (m *Manager) GetGrabberCheckInterval(ctx context.Context) (time.Duration, error) {
+	n, err := getInt(ctx, m, GrabberCheckIntervalMinutes)
+	return time.Duration(n) * time.Minute, err
+}
+func (m *Manager) GetGrabberRunThreshold(ctx context.Context) (time.Duration, error) {
+	n, err := getInt(ctx, m, GrabberRunThresholdMinutes)
+	return time.Duration(n) * time.Minute, err
+}
+func (m *Manager) GetGrabberFullRunThreshold(ctx context.Context) (time.Duration, error) {
+	n, err := getInt(ctx, m, GrabberFullRunThresholdHours)
+	return time.Duration(n) * time.Hour, err
+}
+func (m *Manager) GetRateLimitPerSecond(ctx context.Context) (float64, error) {
+	rate, err := Get[float64](ctx, m, RateLimitPerSecond)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := Validate(RateLimitPerSecond, rate); err != nil {
+		return 0, err
+	}
+	return rate, nil
+}
+func (m *Manager) GetRateLimitBurst(ctx context.Context) (int, error) {
+	return getInt(ctx, m, RateLimitBurst)
+}
 func (m *Manager) GetHiddenNotFoundPosts(ctx context.Context) ([]int, error) {
 	return Get[[]int](ctx, m, HiddenNotFoundPosts)
 }
@@ -27,6 +61,25 @@ func (m *Manager) GetAdminIds(ctx context.Context) ([]int, error) {
 	return Get[[]int](ctx, m, AdminIds)
 }
 
+func (m *Manager) GetConfirmPhrases(ctx context.Context) ([]string, error) {
+	return Get[[]string](ctx, m, ConfirmPhrases)
+}
+
+// GetFoundRules decodes the rule set document directly, which Get cannot do
+// for structs.
+func (m *Manager) GetFoundRules(ctx context.Context) (*FoundRuleSet, error) {
+	var row struct {
+		Value FoundRuleSet `bson:"value"`
+	}
+	if err := m.coll.FindOne(ctx, bson.M{"_id": FoundRules}).Decode(&row); err != nil {
+		return nil, fmt.Errorf("setting %q: %w", FoundRules, err)
+	}
+	if err := row.Value.Check(); err != nil {
+		return nil, fmt.Errorf("setting %q: %w", FoundRules, err)
+	}
+	return &row.Value, nil
+}
+
 
 // SET
 func (m *Manager) SetLastGrabberTime(ctx context.Context, t time.Time) error {
@@ -41,6 +94,22 @@ func (m *Manager) SetLastGrabberStatus(ctx context.Context, status string) error
 	return Set(ctx, m, LastGrabberStatus, status)
 }
 
+func (m *Manager) SetGrabberConcurrency(ctx context.Context, n int) error {
+	return Set(ctx, m, GrabberConcurrency, n)
+}
+
+func (m *Manager) SetGrabberPaused(ctx context.Context, paused bool) error {
+	return Set(ctx, m, GrabberPaused, paused)
+}
+
+func (m *Manager) SetRateLimitPerSecond(ctx context.Context, rate float64) error {
+	return Set(ctx, m, RateLimitPerSecond, rate)
+}
+
+func (m *Manager) SetRateLimitBurst(ctx context.Context, burst int) error {
+	return Set(ctx, m, RateLimitBurst, burst)
+}
+
 func (m *Manager) SetHiddenNotFoundPosts(ctx context.Context, ids []int) error {
 	return Set(ctx, m, HiddenNotFoundPosts, ids)
 }
@@ -51,4 +120,12 @@ func (m *Manager) SetHiddenTags(ctx context.Context, tags []string) error {
 
 func (m *Manager) SetAdminIds(ctx context.Context, ids []int) error {
 	return Set(ctx, m, AdminIds, ids)
+}
+
+func (m *Manager) SetConfirmPhrases(ctx context.Context, phrases []string) error {
+	return Set(ctx, m, ConfirmPhrases, phrases)
+}
+
+func (m *Manager) SetFoundRules(ctx context.Context, rules *FoundRuleSet) error {
+	return Set(ctx, m, FoundRules, rules)
 }
\ No newline at end of file
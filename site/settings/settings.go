@@ -23,6 +23,14 @@ func (m *Manager) GetAll(ctx context.Context) ([]Setting, error) {
 	if err := cursor.All(ctx, &results); err != nil {
 		return nil, fmt.Errorf("settings: %w", err)
 	}
+	// Documents decode as bson.D, which does not encode to JSON as an object.
+	for i := range results {
+		if results[i].Name == FoundRules {
+			if rules, err := m.GetFoundRules(ctx); err == nil {
+				results[i].Value = rules
+			}
+		}
+	}
 	return results, nil
 }
 
@@ -42,6 +50,18 @@ func Get[T any](ctx context.Context, m *Manager, name string) (T, error) {
 		}
 	}
 
+	// handle int32/int64/double → int or float64 conversion
+	if n, ok := numberToInt(setting.Value); ok {
+		var converted interface{} = n
+		if val, ok := converted.(T); ok {
+			return val, nil
+		}
+		converted = numberToFloat(setting.Value)
+		if val, ok := converted.(T); ok {
+			return val, nil
+		}
+	}
+
 	// handle primitive.A → []int or []string conversion
 	if arr, ok := setting.Value.(primitive.A); ok {
 		// Try []int conversion
@@ -92,3 +112,31 @@ func Set(ctx context.Context, m *Manager, name string, value interface{}) error
 	}
 	return nil
 }
+
+func numberToInt(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case int32:
+		return int(n), true
+	case int64:
+		return int(n), true
+	case float64:
+		return int(n), true
+	}
+	return 0, false
+}
+
+func numberToFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
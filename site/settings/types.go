@@ -6,10 +6,26 @@ const (
 	LastGrabberTime     = "last_grabber_time"
 	LastFullGrabberTime = "last_full_grabber_time"
 	LastGrabberStatus   = "last_grabber_status"
+	GrabberConcurrency  = "grabber_concurrency"
+
+	GrabberPaused                = "grabber_paused"
+	GrabberCheckIntervalMinutes  = "grabber_check_interval_minutes"
+	GrabberRunThresholdMinutes   = "grabber_run_threshold_minutes"
+	GrabberFullRunThresholdHours = "grabber_full_run_threshold_hours"
+
+	RateLimitPerSecond = "rate_limit_per_second"
+	RateLimitBurst     = "rate_limit_burst"
 
 	HiddenNotFoundPosts = "hidden_not_found_posts"
 	HiddenTags          = "hidden_tags"
 	AdminIds            = "admin_ids"
+
+	// ConfirmPhrases are what a post author writes in reply to the comment
+	// that found the place, e.g. "да!" or "верно".
+	ConfirmPhrases = "confirm_phrases"
+
+	// FoundRules decides which posts count as found, see FoundRuleSet.
+	FoundRules = "found_rules"
 )
 
 type Manager struct {
@@ -20,3 +36,21 @@ type Setting struct {
 	Name  string      `bson:"_id" json:"name"`
 	Value interface{} `bson:"value" json:"value"`
 }
+
+// FoundRuleSet decides whether a post is found: it must match at least one
+// rule in Found and none in NotFound.
+type FoundRuleSet struct {
+	Found    []FoundRule `bson:"found" json:"found"`
+	NotFound []FoundRule `bson:"not_found,omitempty" json:"not_found,omitempty"`
+}
+
+// FoundRule matches a post if every condition that is set holds.
+type FoundRule struct {
+	// TextPattern and TitlePattern are regular expressions.
+	TextPattern  string `bson:"text_pattern,omitempty" json:"text_pattern,omitempty"`
+	TitlePattern string `bson:"title_pattern,omitempty" json:"title_pattern,omitempty"`
+	// Tag must be among the post's tags, NoTag must not.
+	Tag    string `bson:"tag,omitempty" json:"tag,omitempty"`
+	NoTag  string `bson:"no_tag,omitempty" json:"no_tag,omitempty"`
+	Golden *bool  `bson:"golden,omitempty" json:"golden,omitempty"`
+}
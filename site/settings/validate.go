@@ -0,0 +1,167 @@
+package settings
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+)
+
+type intBounds struct {
+	min, max int
+}
+
+// intSettings lists the integer settings and the range each must fall in.
+var intSettings = map[string]intBounds{
+	GrabberConcurrency:           {1, 16},
+	GrabberCheckIntervalMinutes:  {1, 60},
+	GrabberRunThresholdMinutes:   {10, 7 * 24 * 60},
+	GrabberFullRunThresholdHours: {1, 30 * 24},
+	RateLimitBurst:               {1, 100},
+}
+
+type floatBounds struct {
+	min, max float64
+}
+
+var floatSettings = map[string]floatBounds{
+	RateLimitPerSecond: {0.1, 50},
+}
+
+var boolSettings = map[string]bool{
+	GrabberPaused: true,
+}
+
+// Validate checks a value about to be stored under name and returns it
+// converted to the setting's type. Values decoded from JSON arrive as
+// float64, so integer settings are converted to int here. Settings without
+// a declared type are returned unchanged.
+func Validate(name string, value interface{}) (interface{}, error) {
+	if b, ok := intSettings[name]; ok {
+		f, ok := value.(float64)
+		if !ok {
+			n, isInt := numberToInt(value)
+			if !isInt {
+				return nil, fmt.Errorf("setting %q: expected a number, got %T", name, value)
+			}
+			f = float64(n)
+		}
+		if f != math.Trunc(f) {
+			return nil, fmt.Errorf("setting %q: expected a whole number, got %v", name, f)
+		}
+		n := int(f)
+		if n < b.min || n > b.max {
+			return nil, fmt.Errorf("setting %q: %d is outside %d..%d", name, n, b.min, b.max)
+		}
+		return n, nil
+	}
+
+	if b, ok := floatSettings[name]; ok {
+		if _, isNum := numberToInt(value); !isNum {
+			return nil, fmt.Errorf("setting %q: expected a number, got %T", name, value)
+		}
+		f := numberToFloat(value)
+		if f < b.min || f > b.max {
+			return nil, fmt.Errorf("setting %q: %v is outside %v..%v", name, f, b.min, b.max)
+		}
+		return f, nil
+	}
+
+	if boolSettings[name] {
+		if _, ok := value.(bool); !ok {
+			return nil, fmt.Errorf("setting %q: expected true or false, got %T", name, value)
+		}
+		return value, nil
+	}
+
+	if name == ConfirmPhrases {
+		phrases, err := parsePhrases(value)
+		if err != nil {
+			return nil, fmt.Errorf("setting %q: %w", name, err)
+		}
+		return phrases, nil
+	}
+
+	if name == FoundRules {
+		rules, err := ParseFoundRules(value)
+		if err != nil {
+			return nil, fmt.Errorf("setting %q: %w", name, err)
+		}
+		return rules, nil
+	}
+
+	return value, nil
+}
+
+// parsePhrases accepts a non-empty array of non-blank strings, as decoded
+// from JSON, and returns the strings trimmed.
+func parsePhrases(value interface{}) ([]string, error) {
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a list of phrases, got %T", value)
+	}
+	if len(items) == 0 {
+		return nil, errors.New("at least one phrase is required")
+	}
+	phrases := make([]string, len(items))
+	for i, item := range items {
+		p, ok := item.(string)
+		if !ok || strings.TrimSpace(p) == "" {
+			return nil, fmt.Errorf("phrase %d: expected a non-empty string", i+1)
+		}
+		phrases[i] = strings.TrimSpace(p)
+	}
+	return phrases, nil
+}
+
+// ParseFoundRules converts a rule set decoded from JSON into a FoundRuleSet
+// and checks it.
+func ParseFoundRules(value interface{}) (*FoundRuleSet, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var rules FoundRuleSet
+	if err := json.Unmarshal(raw, &rules); err != nil {
+		return nil, fmt.Errorf("expected a rule set: %w", err)
+	}
+	if err := rules.Check(); err != nil {
+		return nil, err
+	}
+	return &rules, nil
+}
+
+// Check rejects rule sets that could never find a post or that do not
+// compile.
+func (rs *FoundRuleSet) Check() error {
+	if len(rs.Found) == 0 {
+		return errors.New("at least one found rule is required")
+	}
+	for i, r := range append(append([]FoundRule(nil), rs.Found...), rs.NotFound...) {
+		if r == (FoundRule{}) {
+			return fmt.Errorf("rule %d has no conditions", i+1)
+		}
+		for _, p := range []string{r.TextPattern, r.TitlePattern} {
+			if _, err := regexp.Compile(p); err != nil {
+				return fmt.Errorf("rule %d: %w", i+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+// getInt reads an integer setting and rejects stored values that are out of
+// bounds, so callers fall back to their defaults.
+func getInt(ctx context.Context, m *Manager, name string) (int, error) {
+	n, err := Get[int](ctx, m, name)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := Validate(name, n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}